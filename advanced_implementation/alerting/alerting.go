@@ -0,0 +1,167 @@
+// Package alerting tells someone when a receipt may be lost or the pipeline is stuck: a publish that keeps failing, a receipt
+// parked in the dead letter queue, a write to BadgerDB that failed or a queue that is filling up.
+//
+// Every alert is logged at the CRITICAL level. Alerts are also sent to a webhook and by email when those are configured. The
+// outside sinks get at most one alert with the same kind and message per dedupe window and a limited number per hour, so an
+// outage doesn't turn into thousands of emails. Like log lines, alerts carry Ids and counts, never the receipt itself
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"restGo/logging"
+)
+
+// Kind says what went wrong, so alerts can be routed and deduplicated
+type Kind string
+
+const (
+	PublishFailed      Kind = "publish_failed"      // A receipt could not be handed to the message bus
+	DeadLettered       Kind = "dead_lettered"       // The consumer gave up on a receipt, or could not even park it
+	StoreFailed        Kind = "store_failed"        // A write to or read from BadgerDB failed
+	QueueFull          Kind = "queue_full"          // The consumer is too far behind and new receipts are held back
+	ConnectionLost     Kind = "connection_lost"     // The broker went away
+	ShutdownIncomplete Kind = "shutdown_incomplete" // Work was still in progress when the shutdown timeout ran out
+)
+
+// Alert is one occurrence of a problem
+type Alert struct {
+	Kind       Kind
+	Service    string
+	Message    string
+	Attrs      []slog.Attr
+	Time       time.Time
+	RequestID  string
+	Suppressed int // Alerts with the same kind and message left out since the last one was sent
+}
+
+// Alerter sends alerts somewhere
+type Alerter interface {
+	Send(ctx context.Context, alert Alert) error
+}
+
+// Log writes alerts to the log at the CRITICAL level
+type Log struct{}
+
+func (Log) Send(ctx context.Context, alert Alert) error {
+	args := []any{slog.String("alert", string(alert.Kind))}
+	for _, attr := range alert.Attrs {
+		args = append(args, attr)
+	}
+	logging.Critical(ctx, alert.Message, args...)
+	return nil
+}
+
+// Multi sends every alert to each of its alerters
+type Multi []Alerter
+
+func (m Multi) Send(ctx context.Context, alert Alert) error {
+	var errs []error
+	for _, alerter := range m {
+		errs = append(errs, alerter.Send(ctx, alert))
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultAlerter Alerter = Log{}
+	service        string
+)
+
+// SetDefault makes Raise send alerts to alerter, naming the service they come from
+func SetDefault(serviceName string, alerter Alerter) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	service = serviceName
+	defaultAlerter = alerter
+}
+
+// Raise sends an alert to the default alerter, which only logs it unless Setup was called. args are slog style attributes
+func Raise(ctx context.Context, kind Kind, msg string, args ...any) {
+	record := slog.NewRecord(time.Now(), logging.LevelCritical, msg, 0)
+	record.Add(args...)
+	alert := Alert{Kind: kind, Message: msg, Time: record.Time, RequestID: logging.RequestID(ctx)}
+	record.Attrs(func(attr slog.Attr) bool {
+		alert.Attrs = append(alert.Attrs, attr)
+		return true
+	})
+
+	defaultMu.RLock()
+	alert.Service = service
+	alerter := defaultAlerter
+	defaultMu.RUnlock()
+	if err := alerter.Send(ctx, alert); err != nil {
+		slog.WarnContext(ctx, "Failed to send an alert", "alert", string(kind), logging.Err(err))
+	}
+}
+
+// Fields flattens the alert's attributes into text for the sinks that aren't slog
+func (a Alert) Fields() map[string]string {
+	fields := map[string]string{}
+	for _, attr := range a.Attrs {
+		fields[attr.Key] = attr.Value.String()
+	}
+	if a.RequestID != "" {
+		fields[logging.RequestIDKey] = a.RequestID
+	}
+	return fields
+}
+
+// Summary is a one line description of the alert, for a subject or a chat message
+func (a Alert) Summary() string {
+	summary := fmt.Sprintf("[%s] %s: %s", a.Service, a.Kind, a.Message)
+	if a.Suppressed > 0 {
+		summary += fmt.Sprintf(" (and %d more)", a.Suppressed)
+	}
+	return summary
+}
+
+// Config says where alerts go besides the log. Webhook alerts are off without a URL and email alerts without an SMTP server
+type Config struct {
+	WebhookURL   string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	EmailFrom    string
+	EmailTo      []string
+	DedupeWindow time.Duration
+	PerHour      int
+}
+
+// Setup makes Raise log alerts and send them to the webhook and by email if they are configured, each behind its own Throttle.
+// The returned function sends the alerts still queued and has to be called before exiting
+func Setup(serviceName string, config Config) func(context.Context) error {
+	alerters := Multi{Log{}}
+	var throttles []*Throttle
+	if config.WebhookURL != "" {
+		throttles = append(throttles, NewThrottle(Webhook{URL: config.WebhookURL}, config.DedupeWindow, config.PerHour))
+	}
+	if config.SMTPAddr != "" {
+		email := Email{
+			Addr:     config.SMTPAddr,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.EmailFrom,
+			To:       config.EmailTo,
+		}
+		throttles = append(throttles, NewThrottle(email, config.DedupeWindow, config.PerHour))
+	}
+	for _, throttle := range throttles {
+		alerters = append(alerters, throttle)
+	}
+	SetDefault(serviceName, alerters)
+	return func(ctx context.Context) error {
+		SetDefault(serviceName, Log{})
+		var errs []error
+		for _, throttle := range throttles {
+			errs = append(errs, throttle.Close(ctx))
+		}
+		return errors.Join(errs...)
+	}
+}
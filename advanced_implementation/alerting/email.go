@@ -0,0 +1,66 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Email sends alerts through an SMTP server. Username and Password are optional, and only sent over TLS
+type Email struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (e Email) Send(ctx context.Context, alert Alert) error {
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := net.SplitHostPort(e.Addr)
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	// smtp.SendMail has no context, so give up on it at the deadline and let it finish in the background
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(e.Addr, auth, e.From, e.To, e.message(alert)) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e Email) message(alert Alert) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", e.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerSafe(alert.Summary()))
+	fmt.Fprintf(&b, "Date: %s\r\n", alert.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\n", alert.Message)
+	fields := alert.Fields()
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, fields[key])
+	}
+	fmt.Fprintf(&b, "time: %s\r\n", alert.Time.UTC().Format(time.RFC3339))
+	if alert.Suppressed > 0 {
+		fmt.Fprintf(&b, "\r\n%d more like it were left out since the last email\r\n", alert.Suppressed)
+	}
+	return []byte(b.String())
+}
+
+// headerSafe keeps a value from starting a new header line
+func headerSafe(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
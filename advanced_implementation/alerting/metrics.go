@@ -0,0 +1,11 @@
+package alerting
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var alertsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "alerts_total",
+	Help: "Alerts for the webhook and email, by kind and outcome: sent, failed, suppressed as a repeat or over the limit, or dropped",
+}, []string{"kind", "outcome"})
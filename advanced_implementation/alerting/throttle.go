@@ -0,0 +1,129 @@
+package alerting
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"restGo/logging"
+)
+
+// How long a sink gets to send one alert
+const sendTimeout = 10 * time.Second
+
+type queued struct {
+	ctx   context.Context
+	alert Alert
+}
+
+// Throttle sits in front of a sink that notifies people. It lets through the first alert with a given kind and message, leaves
+// out the repeats for the dedupe window and counts them in the next one that goes out, and sends at most perHour alerts an
+// hour in all. Alerts are sent in the background, so a slow mail server never holds up a receipt
+type Throttle struct {
+	next    Alerter
+	window  time.Duration
+	perHour int
+
+	mu         sync.Mutex
+	lastSent   map[string]time.Time
+	suppressed map[string]int
+	tokens     float64
+	refilled   time.Time
+	closed     bool
+
+	queue chan queued
+	done  chan struct{}
+}
+
+func NewThrottle(next Alerter, window time.Duration, perHour int) *Throttle {
+	t := &Throttle{
+		next:       next,
+		window:     window,
+		perHour:    perHour,
+		lastSent:   map[string]time.Time{},
+		suppressed: map[string]int{},
+		tokens:     float64(perHour),
+		refilled:   time.Now(),
+		queue:      make(chan queued, 64),
+		done:       make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Send queues the alert unless it is a repeat or the hourly limit is used up
+func (t *Throttle) Send(ctx context.Context, alert Alert) error {
+	if !t.allow(&alert) {
+		alertsMetric.WithLabelValues(string(alert.Kind), "suppressed").Inc()
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil // Shutting down, the alert was logged anyway
+	}
+	select {
+	case t.queue <- queued{ctx: context.WithoutCancel(ctx), alert: alert}:
+	default:
+		alertsMetric.WithLabelValues(string(alert.Kind), "dropped").Inc()
+		slog.WarnContext(ctx, "Too many alerts waiting to be sent, dropping one", "alert", string(alert.Kind))
+	}
+	return nil
+}
+
+// allow decides whether the alert goes out, and if so adds the count of the repeats left out before it
+func (t *Throttle) allow(alert *Alert) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := alert.Time
+	key := string(alert.Kind) + "\x00" + alert.Message
+	if last, ok := t.lastSent[key]; ok && now.Sub(last) < t.window {
+		t.suppressed[key]++
+		return false
+	}
+	// Refill the tokens for the time since the last alert, up to an hour's worth
+	t.tokens += now.Sub(t.refilled).Hours() * float64(t.perHour)
+	t.tokens = min(t.tokens, float64(t.perHour))
+	t.refilled = now
+	if t.tokens < 1 {
+		t.suppressed[key]++
+		return false
+	}
+	t.tokens--
+	t.lastSent[key] = now
+	alert.Suppressed = t.suppressed[key]
+	delete(t.suppressed, key)
+	return true
+}
+
+func (t *Throttle) run() {
+	defer close(t.done)
+	for q := range t.queue {
+		ctx, cancel := context.WithTimeout(q.ctx, sendTimeout)
+		err := t.next.Send(ctx, q.alert)
+		cancel()
+		if err != nil {
+			alertsMetric.WithLabelValues(string(q.alert.Kind), "failed").Inc()
+			slog.WarnContext(q.ctx, "Failed to send an alert", "alert", string(q.alert.Kind), logging.Err(err))
+			continue
+		}
+		alertsMetric.WithLabelValues(string(q.alert.Kind), "sent").Inc()
+	}
+}
+
+// Close sends the alerts still queued, giving up when ctx is done. Alerts raised after it are only logged
+func (t *Throttle) Close(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,190 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// recorder is a sink that keeps what it was sent. If block is set, each send waits for it after saying it started
+type recorder struct {
+	mu      sync.Mutex
+	alerts  []Alert
+	err     error
+	started chan struct{}
+	block   chan struct{}
+}
+
+func (r *recorder) Send(ctx context.Context, alert Alert) error {
+	if r.block != nil {
+		r.started <- struct{}{}
+		<-r.block
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, alert)
+	return r.err
+}
+
+// sent returns the messages the sink got, each with the count of repeats left out before it
+func (r *recorder) sent() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sent []string
+	for _, alert := range r.alerts {
+		sent = append(sent, fmt.Sprintf("%s+%d", alert.Message, alert.Suppressed))
+	}
+	return sent
+}
+
+// newTestThrottle starts a throttle whose clock starts at start. The alerts' own times move it on from there
+func newTestThrottle(t *testing.T, sink Alerter, window time.Duration, perHour int, start time.Time) *Throttle {
+	t.Helper()
+	throttle := NewThrottle(sink, window, perHour)
+	throttle.refilled = start
+	return throttle
+}
+
+func closeThrottle(t *testing.T, throttle *Throttle) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := throttle.Close(ctx); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+}
+
+// countOutcomes returns a function that reads how many alerts of a kind have had each outcome since it was called. Each test
+// uses a kind of its own
+func countOutcomes(kind Kind) func() map[string]int {
+	read := func() map[string]int {
+		counts := map[string]int{}
+		for _, outcome := range []string{"sent", "failed", "suppressed", "dropped"} {
+			var metric dto.Metric
+			alertsMetric.WithLabelValues(string(kind), outcome).Write(&metric)
+			counts[outcome] = int(metric.GetCounter().GetValue())
+		}
+		return counts
+	}
+	before := read()
+	return func() map[string]int {
+		counts := map[string]int{}
+		for outcome, count := range read() {
+			if count > before[outcome] {
+				counts[outcome] = count - before[outcome]
+			}
+		}
+		return counts
+	}
+}
+
+func TestThrottleLeavesOutRepeats(t *testing.T) {
+	const kind Kind = "test_repeats"
+	outcomes := countOutcomes(kind)
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	sink := &recorder{}
+	throttle := newTestThrottle(t, sink, time.Minute, 100, start)
+	send := func(after time.Duration, msg string) {
+		throttle.Send(context.Background(), Alert{Kind: kind, Message: msg, Time: start.Add(after)})
+	}
+
+	send(0, "disk full")
+	send(10*time.Second, "disk full")    // A repeat within the window
+	send(20*time.Second, "disk full")    // And another
+	send(30*time.Second, "broker down")  // A different message goes out
+	send(61*time.Second, "disk full")    // The window has passed, so it goes out with the count of the repeats
+	send(90*time.Second, "disk full")    // The window starts again from the last one sent
+	send(85*time.Second, "broker down")  // Still within the window of the first one
+	send(200*time.Second, "broker down") // Goes out with the one left out
+	closeThrottle(t, throttle)
+
+	want := []string{"disk full+0", "broker down+0", "disk full+2", "broker down+1"}
+	if got := sink.sent(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+	if got := outcomes(); got["sent"] != 4 || got["suppressed"] != 4 || len(got) != 2 {
+		t.Errorf("outcomes = %v, want 4 sent and 4 suppressed", got)
+	}
+}
+
+func TestThrottleLimitsAlertsPerHour(t *testing.T) {
+	const kind Kind = "test_per_hour"
+	outcomes := countOutcomes(kind)
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	sink := &recorder{}
+	throttle := newTestThrottle(t, sink, time.Minute, 2, start)
+	send := func(after time.Duration, msg string) {
+		throttle.Send(context.Background(), Alert{Kind: kind, Message: msg, Time: start.Add(after)})
+	}
+
+	send(0, "a")
+	send(time.Second, "b")
+	send(2*time.Second, "c") // Over the limit
+	// Half an hour refills one of the two an hour
+	send(30*time.Minute, "c")
+	send(30*time.Minute+time.Second, "d") // Over the limit again
+	// However long it has been, no more than an hour's worth is saved up
+	send(5*time.Hour, "d")
+	send(5*time.Hour+time.Second, "e")
+	send(5*time.Hour+2*time.Second, "f")
+	closeThrottle(t, throttle)
+
+	want := []string{"a+0", "b+0", "c+1", "d+1", "e+0"}
+	if got := sink.sent(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+	if got := outcomes(); got["sent"] != 5 || got["suppressed"] != 3 || len(got) != 2 {
+		t.Errorf("outcomes = %v, want 5 sent and 3 suppressed", got)
+	}
+}
+
+func TestThrottleDropsWhenTheQueueIsFull(t *testing.T) {
+	const kind Kind = "test_dropped"
+	outcomes := countOutcomes(kind)
+	sink := &recorder{started: make(chan struct{}, 1), block: make(chan struct{})}
+	throttle := newTestThrottle(t, sink, time.Minute, 1000, time.Now())
+	send := func(i int) {
+		throttle.Send(context.Background(), Alert{Kind: kind, Message: fmt.Sprint(i), Time: time.Now()})
+	}
+
+	send(0)
+	<-sink.started // The sink is busy with the first one
+	queued := cap(throttle.queue)
+	for i := 1; i <= queued+1; i++ {
+		send(i)
+	}
+	if got := outcomes(); got["dropped"] != 1 {
+		t.Errorf("outcomes = %v with the queue full, want 1 dropped", got)
+	}
+	close(sink.block)
+	for range queued {
+		<-sink.started
+	}
+	closeThrottle(t, throttle)
+	if got := outcomes(); got["sent"] != queued+1 || got["dropped"] != 1 {
+		t.Errorf("outcomes = %v, want %d sent and 1 dropped", got, queued+1)
+	}
+}
+
+func TestThrottleCountsFailedSends(t *testing.T) {
+	const kind Kind = "test_failed"
+	outcomes := countOutcomes(kind)
+	sink := &recorder{err: errors.New("mail server down")}
+	throttle := newTestThrottle(t, sink, time.Minute, 10, time.Now())
+	throttle.Send(context.Background(), Alert{Kind: kind, Message: "disk full", Time: time.Now()})
+	closeThrottle(t, throttle)
+
+	if got := outcomes(); got["failed"] != 1 || len(got) != 1 {
+		t.Errorf("outcomes = %v, want 1 failed", got)
+	}
+	// Once closed, alerts are only logged
+	throttle.Send(context.Background(), Alert{Kind: kind, Message: "broker down", Time: time.Now()})
+	if sent := sink.sent(); len(sent) != 1 {
+		t.Errorf("sent %v after Close, want only the first", sent)
+	}
+}
@@ -0,0 +1,69 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Webhook posts alerts as JSON. The text field makes it work as is with Slack and Mattermost incoming webhooks
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+type webhookPayload struct {
+	Text       string            `json:"text"`
+	Kind       Kind              `json:"kind"`
+	Service    string            `json:"service"`
+	Message    string            `json:"message"`
+	Fields     map[string]string `json:"fields"`
+	Time       time.Time         `json:"time"`
+	Suppressed int               `json:"suppressed"`
+}
+
+func (w Webhook) Send(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(webhookPayload{
+		Text:       alert.Summary(),
+		Kind:       alert.Kind,
+		Service:    alert.Service,
+		Message:    alert.Message,
+		Fields:     alert.Fields(),
+		Time:       alert.Time.UTC(),
+		Suppressed: alert.Suppressed,
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		// The URL can hold a token, so only say which part failed
+		return fmt.Errorf("webhook: %w", unwrapURLError(err))
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook: answered %s", response.Status)
+	}
+	return nil
+}
+
+// unwrapURLError drops the *url.Error around a failed request, whose message repeats the whole URL
+func unwrapURLError(err error) error {
+	if unwrapped := errors.Unwrap(err); unwrapped != nil {
+		return unwrapped
+	}
+	return err
+}
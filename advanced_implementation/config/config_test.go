@@ -0,0 +1,61 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBoolFlagWithoutValue(t *testing.T) {
+	t.Setenv("REQUIRE_AUTH", "")
+	config, _, err := Load([]string{"--require-auth", "--workers", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.RequireAuth {
+		t.Error("--require-auth without a value did not turn auth on")
+	}
+	if config.Workers != 3 {
+		t.Errorf("workers = %d, want 3", config.Workers)
+	}
+
+	config, _, err = Load([]string{"--require-auth=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RequireAuth {
+		t.Error("--require-auth=false turned auth on")
+	}
+}
+
+func TestLoadFileNumbers(t *testing.T) {
+	t.Setenv("MAX_TOTAL", "")
+	t.Setenv("WRITE_BATCH_SIZE", "")
+	t.Setenv("REQUIRE_AUTH", "")
+	path := filepath.Join(t.TempDir(), "config.json")
+	body := `{"max-total": 1000000, "write-batch-size": 250, "require-auth": true}`
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, _, err := Load([]string{"--config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MaxTotal != 1000000 {
+		t.Errorf("max-total = %d, want 1000000", config.MaxTotal)
+	}
+	if config.WriteBatchSize != 250 {
+		t.Errorf("write-batch-size = %d, want 250", config.WriteBatchSize)
+	}
+	if !config.RequireAuth {
+		t.Error("require-auth from the file was not applied")
+	}
+}
+
+func TestValidateRejectsInProcessBus(t *testing.T) {
+	config := Defaults()
+	config.MessageBus = "inprocess"
+	if err := config.Validate(); err == nil {
+		t.Fatal("Validate() accepted the in-process bus")
+	}
+}
@@ -0,0 +1,163 @@
+// Package health serves the liveness and readiness endpoints of the server and the consumer. /healthz answers as long as the
+// process is serving HTTP, so an orchestrator only restarts a process that is stuck. /readyz runs the checks and answers 503 while
+// a required one fails, so traffic only goes to a process that can actually take receipts
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// How long the checks together get to answer
+const checkTimeout = 2 * time.Second
+
+// Check returns a short description of what it found, or why it failed
+type Check func(ctx context.Context) (string, error)
+
+type check struct {
+	name     string
+	required bool
+	run      Check
+}
+
+// Checker holds the checks behind /readyz
+type Checker struct {
+	mu     sync.RWMutex
+	checks []check
+}
+
+// Add registers a check. A failing required check makes the process not ready, any other is only reported
+func (c *Checker) Add(name string, required bool, run Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, check{name: name, required: required, run: run})
+}
+
+// Result is what one check found
+type Result struct {
+	Status   string `json:"status"` // ok or failing
+	Required bool   `json:"required"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// Report is the body of /readyz
+type Report struct {
+	Status string            `json:"status"` // ready, degraded when only checks that aren't required fail, or not ready
+	Checks map[string]Result `json:"checks"`
+}
+
+// Run runs every check and says whether the process is ready
+func (c *Checker) Run(ctx context.Context) (Report, bool) {
+	c.mu.RLock()
+	checks := c.checks
+	c.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	report := Report{Status: "ready", Checks: map[string]Result{}}
+	ready := true
+	for _, check := range checks {
+		detail, err := check.run(ctx)
+		result := Result{Status: "ok", Required: check.required, Detail: detail}
+		if err != nil {
+			result.Status, result.Detail = "failing", err.Error()
+			switch {
+			case check.required:
+				ready = false
+				report.Status = "not ready"
+			case ready:
+				report.Status = "degraded"
+			}
+		}
+		report.Checks[check.name] = result
+	}
+	return report, ready
+}
+
+// Ready serves /readyz
+func (c *Checker) Ready() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, ready := c.Run(r.Context())
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// Live serves /healthz
+func Live() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(`{"status":"ok"}` + "\n"))
+	})
+}
+
+// Badger checks that a BadgerDB still answers reads
+func Badger(db *badger.DB) Check {
+	return func(ctx context.Context) (string, error) {
+		err := db.View(func(txn *badger.Txn) error {
+			_, err := txn.Get([]byte("health check"))
+			return err
+		})
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return "", err
+		}
+		return "", nil
+	}
+}
+
+// Flag is a check that fails with the given reason until it is set, e.g. until a startup step has finished
+type Flag struct {
+	reason string
+	mu     sync.RWMutex
+	set    bool
+	err    error
+}
+
+func NewFlag(reason string) *Flag {
+	return &Flag{reason: reason}
+}
+
+func (f *Flag) Set() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set = true
+}
+
+// Fail makes the check report err instead of the reason, for a startup step that gave up. The flag stays unset
+func (f *Flag) Fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *Flag) IsSet() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.set
+}
+
+func (f *Flag) Check(ctx context.Context) (string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	switch {
+	case f.set:
+		return "", nil
+	case f.err != nil:
+		return "", f.err
+	default:
+		return "", errors.New(f.reason)
+	}
+}
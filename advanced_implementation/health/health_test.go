@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func passing(detail string) Check {
+	return func(ctx context.Context) (string, error) {
+		return detail, nil
+	}
+}
+
+func failing(reason string) Check {
+	return func(ctx context.Context) (string, error) {
+		return "", errors.New(reason)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		required   Check
+		optional   Check
+		wantStatus string
+		wantCode   int
+		wantDetail string // Of the required check
+	}{
+		{"all passing", passing("3 waiting"), passing(""), "ready", http.StatusOK, "3 waiting"},
+		{"optional failing", passing("3 waiting"), failing("broker down"), "degraded", http.StatusOK, "3 waiting"},
+		{"required failing", failing("disk gone"), passing(""), "not ready", http.StatusServiceUnavailable, "disk gone"},
+		{"both failing", failing("disk gone"), failing("broker down"), "not ready", http.StatusServiceUnavailable, "disk gone"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checker := &Checker{}
+			checker.Add("store", true, test.required)
+			checker.Add("bus", false, test.optional)
+
+			response := httptest.NewRecorder()
+			checker.Ready().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if response.Code != test.wantCode {
+				t.Errorf("/readyz = %d, want %d", response.Code, test.wantCode)
+			}
+			if response.Header().Get("Cache-Control") != "no-store" {
+				t.Error("/readyz can be cached")
+			}
+			var report Report
+			if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != test.wantStatus {
+				t.Errorf("status = %q, want %q", report.Status, test.wantStatus)
+			}
+			store, bus := report.Checks["store"], report.Checks["bus"]
+			if !store.Required || bus.Required {
+				t.Errorf("checks = %+v, want only store required", report.Checks)
+			}
+			if store.Detail != test.wantDetail {
+				t.Errorf("store detail = %q, want %q", store.Detail, test.wantDetail)
+			}
+		})
+	}
+}
+
+func TestLive(t *testing.T) {
+	response := httptest.NewRecorder()
+	Live().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if response.Code != http.StatusOK || response.Body.String() != `{"status":"ok"}`+"\n" {
+		t.Errorf("/healthz = %d %s", response.Code, response.Body)
+	}
+}
+
+func TestFlag(t *testing.T) {
+	flag := NewFlag("still starting")
+	if _, err := flag.Check(context.Background()); err == nil || err.Error() != "still starting" || flag.IsSet() {
+		t.Errorf("Check() = %v before Set, want still starting", err)
+	}
+	flag.Fail(errors.New("disk gone"))
+	if _, err := flag.Check(context.Background()); err == nil || err.Error() != "disk gone" || flag.IsSet() {
+		t.Errorf("Check() = %v after Fail, want disk gone", err)
+	}
+	flag.Set()
+	if _, err := flag.Check(context.Background()); err != nil || !flag.IsSet() {
+		t.Errorf("Check() = %v after Set", err)
+	}
+}
+
+func TestBadger(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// An empty database is fine, the key it reads is never there
+	if _, err := Badger(db)(context.Background()); err != nil {
+		t.Errorf("Badger() = %v", err)
+	}
+}
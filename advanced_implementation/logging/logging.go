@@ -0,0 +1,122 @@
+// Package logging sets up log/slog for the server and the consumer, and carries the request ID of a receipt from the HTTP request
+// through the message bus to the consumer, so every line about it can be found with one search.
+//
+// Log the receipt Id, points and counts, never the receipt itself: retailer names, items and totals stay out of the logs, and so
+// do broker URLs, which can hold a password
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// LevelCritical is for what someone has to look at, such as a receipt that may be lost. alerting.Raise logs alerts at this level
+const LevelCritical = slog.LevelError + 4
+
+// Attribute keys used across the binaries
+const (
+	RequestIDKey = "request_id"
+	ReceiptIDKey = "receipt_id"
+)
+
+// Receipt is the attribute for a receipt Id
+func Receipt(Id string) slog.Attr {
+	return slog.String(ReceiptIDKey, Id)
+}
+
+// Err is the attribute for an error
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose log lines carry the request ID. An empty ID leaves the context as it is
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	if requestID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// handler adds the request ID of the context to every record logged with one of the Context functions, e.g. slog.InfoContext
+type handler struct {
+	slog.Handler
+}
+
+func (h handler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		record.AddAttrs(slog.String(RequestIDKey, requestID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return handler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h handler) WithGroup(name string) slog.Handler {
+	return handler{h.Handler.WithGroup(name)}
+}
+
+// ParseLevel reads debug, info, warn or error
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil || strings.ContainsAny(name, "+-") {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// Setup makes slog's default logger write to stdout at the given level, as "json" or "text"
+func Setup(levelName, format string) error {
+	level, err := ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+	options := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.LevelKey && attr.Value.Any() == LevelCritical {
+				attr.Value = slog.StringValue("CRITICAL")
+			}
+			return attr
+		},
+	}
+	var base slog.Handler
+	switch format {
+	case "json":
+		base = slog.NewJSONHandler(os.Stdout, options)
+	case "text":
+		base = slog.NewTextHandler(os.Stdout, options)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	slog.SetDefault(slog.New(handler{base}))
+	return nil
+}
+
+// Critical logs at LevelCritical
+func Critical(ctx context.Context, msg string, args ...any) {
+	slog.Log(ctx, LevelCritical, msg, args...)
+}
+
+// Library adapts slog to the printf style logger interfaces of libraries such as BadgerDB, tagging each line with the library
+type Library string
+
+func (l Library) log(level slog.Level, format string, args ...any) {
+	slog.Log(context.Background(), level, strings.TrimSpace(fmt.Sprintf(format, args...)), "component", string(l))
+}
+
+func (l Library) Errorf(format string, args ...any)   { l.log(slog.LevelError, format, args...) }
+func (l Library) Warningf(format string, args ...any) { l.log(slog.LevelWarn, format, args...) }
+func (l Library) Infof(format string, args ...any)    { l.log(slog.LevelInfo, format, args...) }
+func (l Library) Debugf(format string, args ...any)   { l.log(slog.LevelDebug, format, args...) }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/dgraph-io/badger"
+	"restGo/alerting"
+	"restGo/logging"
+	"restGo/scoring"
+)
+
+const rescoreBatchSize = 100 // Records rewritten per transaction, small enough to stay clear of ErrTxnTooBig
+
+// rescoreStored rewrites every stored record that was scored under a different ruleset, so the database stays the
+// authoritative source of points after the rules change. It runs next to the pipeline and reports its progress as it goes.
+// It stops between batches once ctx is done, and the next start picks up whatever is still stale
+func rescoreStored(ctx context.Context, db *badger.DB, rules scoring.Ruleset, progress *scoring.RescoreProgress) {
+	stale := []string{}
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			err := item.Value(func(v []byte) error {
+				var record scoring.Record
+				json.Unmarshal(v, &record)
+				if record.Stale(rules) {
+					stale = append(stale, string(item.KeyCopy(nil)))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		alerting.Raise(ctx, alerting.StoreFailed, "Failed to scan the database for stale points", logging.Err(err))
+		return
+	}
+	progress.Start(rules.Version, len(stale))
+	if len(stale) == 0 {
+		return
+	}
+	slog.Info("Rescoring stale receipts", "count", len(stale), "ruleset_version", rules.Version)
+	rescoreInBatches(ctx, stale, progress, func(Ids []string) (int, int, error) {
+		return rescoreBatch(db, rules, Ids)
+	})
+}
+
+// rescoreInBatches hands the Ids to batch rescoreBatchSize at a time and adds up the progress
+func rescoreInBatches(ctx context.Context, Ids []string, progress *scoring.RescoreProgress, batch func(Ids []string) (int, int, error)) {
+	for start := 0; start < len(Ids); start += rescoreBatchSize {
+		if ctx.Err() != nil {
+			slog.Info("Rescore stopped for shutdown", "progress", progress.Status())
+			return
+		}
+		end := min(start+rescoreBatchSize, len(Ids))
+		rescored, failed, err := batch(Ids[start:end])
+		if errors.Is(err, badger.ErrConflict) {
+			// The pipeline wrote one of these receipts at the same time, so just go through the batch again
+			start -= rescoreBatchSize
+			continue
+		}
+		if err != nil {
+			alerting.Raise(ctx, alerting.StoreFailed, "Rescore stopped", logging.Err(err))
+			return
+		}
+		progress.Add(rescored, failed)
+		slog.Info("Rescore", "progress", progress.Status())
+	}
+	progress.Finish()
+}
+
+// rescoreBatch rescores a batch of records in one transaction. A record is checked again inside the transaction in case the
+// pipeline already replaced it with a fresh one
+func rescoreBatch(db *badger.DB, rules scoring.Ruleset, Ids []string) (int, int, error) {
+	rescored, failed := 0, 0
+	err := db.Update(func(txn *badger.Txn) error {
+		rescored, failed = 0, 0
+		for _, Id := range Ids {
+			item, err := txn.Get([]byte(Id))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			var record scoring.Record
+			err = item.Value(func(v []byte) error {
+				return json.Unmarshal(v, &record)
+			})
+			if err != nil || !record.Stale(rules) {
+				continue
+			}
+			record.Id = Id
+			fresh, err := scoring.Score(rules, record.Receipt)
+			if err != nil {
+				slog.Warn("Receipt can't be scored under the ruleset", logging.Receipt(Id), "ruleset_version", rules.Version, logging.Err(err))
+				failed++
+				continue
+			}
+			body, _ := json.Marshal(fresh)
+			if err := txn.Set([]byte(Id), body); err != nil {
+				return err
+			}
+			rescored++
+		}
+		return nil
+	})
+	return rescored, failed, err
+}
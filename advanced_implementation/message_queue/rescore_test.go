@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"restGo/scoring"
+)
+
+// The next ruleset version, under which every receipt gets more points for its retailer name
+func nextRuleset() scoring.Ruleset {
+	rules := scoring.DefaultRuleset
+	rules.Version++
+	rules.PointsPerRetailerChar++
+	return rules
+}
+
+func openTestDB(t *testing.T) *badger.DB {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithSyncWrites(false).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testReceipt(Id string) scoring.Receipt {
+	return scoring.Receipt{
+		Id:           Id,
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []scoring.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	}
+}
+
+func putRecord(t *testing.T, db *badger.DB, record scoring.Record) {
+	t.Helper()
+	body, _ := json.Marshal(record)
+	err := db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(record.Id), body)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storeScored stores n receipts scored under the given ruleset and returns their Ids
+func storeScored(t *testing.T, db *badger.DB, rules scoring.Ruleset, n int) []string {
+	t.Helper()
+	var Ids []string
+	for i := range n {
+		record, err := scoring.Score(rules, testReceipt(fmt.Sprintf("r%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		putRecord(t, db, record)
+		Ids = append(Ids, record.Id)
+	}
+	return Ids
+}
+
+func storedRecord(t *testing.T, db *badger.DB, Id string) scoring.Record {
+	t.Helper()
+	var record scoring.Record
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(Id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &record)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// versions counts the stored records by the ruleset version they were scored under
+func versions(t *testing.T, db *badger.DB, Ids []string) map[int]int {
+	t.Helper()
+	counts := map[int]int{}
+	for _, Id := range Ids {
+		counts[storedRecord(t, db, Id).RulesetVersion]++
+	}
+	return counts
+}
+
+func TestRescoreStored(t *testing.T) {
+	db := openTestDB(t)
+	rules := nextRuleset()
+	Ids := storeScored(t, db, scoring.DefaultRuleset, 250) // More than two batches
+	fresh, _ := scoring.Score(rules, testReceipt("fresh"))
+	putRecord(t, db, fresh)
+	corrupt := scoring.Record{Receipt: testReceipt("corrupt"), RulesetVersion: scoring.DefaultRuleset.Version}
+	corrupt.Total = "lots"
+	putRecord(t, db, corrupt)
+
+	progress := &scoring.RescoreProgress{}
+	rescoreStored(context.Background(), db, rules, progress)
+	want := scoring.RescoreStatus{RulesetVersion: rules.Version, Total: 251, Rescored: 250, Failed: 1, Finished: true}
+	if got := progress.Status(); got != want {
+		t.Errorf("progress = %+v, want %+v", got, want)
+	}
+	wantPoints, _ := scoring.Score(rules, testReceipt("r000"))
+	for _, Id := range Ids {
+		record := storedRecord(t, db, Id)
+		if record.RulesetVersion != rules.Version || record.Points != wantPoints.Points || record.Id != Id {
+			t.Fatalf("stored %s with %d points under version %d, want %d under version %d", Id, record.Points, record.RulesetVersion, wantPoints.Points, rules.Version)
+		}
+	}
+	// A receipt that can't be scored is left as it was rather than lost
+	if record := storedRecord(t, db, "corrupt"); record.RulesetVersion != scoring.DefaultRuleset.Version || record.Total != "lots" {
+		t.Errorf("the corrupt record became %+v", record)
+	}
+}
+
+func TestRescoreRetriesBatchOnConflict(t *testing.T) {
+	Ids := make([]string, 250)
+	for i := range Ids {
+		Ids[i] = fmt.Sprint(i)
+	}
+	var batches []string
+	conflicted := false
+	progress := &scoring.RescoreProgress{}
+	progress.Start(2, len(Ids))
+	rescoreInBatches(context.Background(), Ids, progress, func(batch []string) (int, int, error) {
+		batches = append(batches, batch[0]+"-"+batch[len(batch)-1])
+		if batch[0] == "100" && !conflicted {
+			conflicted = true
+			return 0, 0, badger.ErrConflict // The pipeline wrote one of these receipts meanwhile
+		}
+		return len(batch), 0, nil
+	})
+
+	want := "[0-99 100-199 100-199 200-249]"
+	if got := fmt.Sprint(batches); got != want {
+		t.Errorf("batches %s, want %s", got, want)
+	}
+	if status := progress.Status(); status.Rescored != 250 || !status.Finished {
+		t.Errorf("progress = %+v, want all 250 rescored once", status)
+	}
+}
+
+func TestRescoreResumesAfterShutdown(t *testing.T) {
+	db := openTestDB(t)
+	rules := nextRuleset()
+	Ids := storeScored(t, db, scoring.DefaultRuleset, 250)
+
+	// Shut down after the first batch
+	ctx, cancel := context.WithCancel(context.Background())
+	progress := &scoring.RescoreProgress{}
+	progress.Start(rules.Version, len(Ids))
+	rescoreInBatches(ctx, Ids, progress, func(batch []string) (int, int, error) {
+		defer cancel()
+		return rescoreBatch(db, rules, batch)
+	})
+	if status := progress.Status(); status.Rescored != rescoreBatchSize || status.Finished {
+		t.Fatalf("progress = %+v after stopping, want one unfinished batch", status)
+	}
+	if got := versions(t, db, Ids); got[rules.Version] != rescoreBatchSize {
+		t.Fatalf("versions after stopping = %v, want %d rescored", got, rescoreBatchSize)
+	}
+
+	// The next start only has the rest to do
+	progress = &scoring.RescoreProgress{}
+	rescoreStored(context.Background(), db, rules, progress)
+	want := scoring.RescoreStatus{RulesetVersion: rules.Version, Total: 250 - rescoreBatchSize, Rescored: 250 - rescoreBatchSize, Finished: true}
+	if got := progress.Status(); got != want {
+		t.Errorf("progress = %+v after restarting, want %+v", got, want)
+	}
+	if got := versions(t, db, Ids); got[rules.Version] != 250 {
+		t.Errorf("versions after restarting = %v, want all 250 rescored", got)
+	}
+}
+
+// A record the pipeline stored under the new ruleset after the scan isn't rescored over
+func TestRescoreBatchSkipsFreshRecords(t *testing.T) {
+	db := openTestDB(t)
+	rules := nextRuleset()
+	Ids := storeScored(t, db, scoring.DefaultRuleset, 2)
+	fresh, _ := scoring.Score(rules, testReceipt(Ids[0]))
+	fresh.Points = 1000 // So a rescore would show
+	putRecord(t, db, fresh)
+
+	rescored, failed, err := rescoreBatch(db, rules, append(Ids, "missing"))
+	if err != nil || rescored != 1 || failed != 0 {
+		t.Errorf("rescoreBatch() = %d, %d, %v, want 1 rescored", rescored, failed, err)
+	}
+	if record := storedRecord(t, db, Ids[0]); record.Points != 1000 {
+		t.Errorf("the fresh record was rescored to %d points", record.Points)
+	}
+}
@@ -0,0 +1,39 @@
+package messaging
+
+import "context"
+
+// Backlog is how far the consumer is behind
+type Backlog struct {
+	Depth   int    // Receipts waiting to be processed
+	Blocked string // Set when the broker itself is pushing back on publishers, with the reason
+}
+
+// BacklogReporter is implemented by the publishers that can tell how far behind the consumer is
+type BacklogReporter interface {
+	Backlog(ctx context.Context) (Backlog, error)
+}
+
+func (p *RabbitMQPublisher) Backlog(ctx context.Context) (Backlog, error) {
+	queue, err := InspectQueue(p.conn.Current(), ReceiptsQueue)
+	if err != nil {
+		return Backlog{}, err
+	}
+	return Backlog{Depth: queue.Messages, Blocked: p.conn.Blocked()}, nil
+}
+
+// Backlog counts the receipts the durable consumer has not acked yet. It fails until the consumer has started once
+func (p *NATSPublisher) Backlog(ctx context.Context) (Backlog, error) {
+	consumer, err := p.js.Consumer(ctx, natsStream, natsConsumer)
+	if err != nil {
+		return Backlog{}, err
+	}
+	info, err := consumer.Info(ctx)
+	if err != nil {
+		return Backlog{}, err
+	}
+	return Backlog{Depth: int(info.NumPending) + info.NumAckPending}, nil
+}
+
+func (b *InProcessBus) Backlog(ctx context.Context) (Backlog, error) {
+	return Backlog{Depth: len(b.messages)}, nil
+}
@@ -0,0 +1,116 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"restGo/logging"
+)
+
+// Message is a receipt on its way from the server to the consumer, whichever broker carries it
+type Message struct {
+	Id      string // Receipt Id. Brokers use it to spot duplicates and, where they can, to keep one receipt's messages in order
+	Body    []byte
+	Headers map[string]string
+}
+
+// ErrPoison marks a message that can never be processed. A Handler returns an error wrapping it to have the message parked
+// straight away instead of retried
+var ErrPoison = errors.New("poison message")
+
+// Publisher hands messages to a broker
+type Publisher interface {
+	// Publish returns nil only once the broker has taken responsibility for the message
+	Publish(ctx context.Context, msg Message) error
+	Close() error
+}
+
+// Handler processes one message. nil settles it, an error wrapping ErrPoison parks it, and any other error has it retried after
+// each of the RetryTiers delays in turn before it is parked
+type Handler func(ctx context.Context, msg Message) error
+
+// Subscriber delivers messages to a Handler
+type Subscriber interface {
+	// Subscribe blocks, calling handler for every message, until ctx is done or the subscription fails for good. Once ctx is
+	// done it stops taking new messages and returns nil when the ones already being handled are settled. Handlers are given a
+	// context that is not cancelled along with ctx, so they can finish their work. ready is called once, when the broker has
+	// accepted the subscription and messages are on their way, so a caller can wait for it before reporting itself ready
+	Subscribe(ctx context.Context, handler Handler, ready func()) error
+	Close() error
+}
+
+// Supported brokers
+const (
+	RabbitMQ  = "rabbitmq"
+	NATS      = "nats"
+	Kafka     = "kafka"
+	InProcess = "inprocess" // Only for tests that run the server and the consumer in one process, see NewInProcessBus
+)
+
+// The server and the consumer are separate processes, so an in-process bus between them would take receipts that nothing
+// ever consumes, and lose them on the next restart
+var errInProcessOnly = errors.New("the in-process bus only works within one process, create one with NewInProcessBus")
+
+// BusConfig says which broker to use and how to reach it
+type BusConfig struct {
+	Kind         string
+	RabbitMQURL  string
+	NATSURL      string
+	KafkaBrokers []string
+
+	ReceiptsQueue   string // Also the Kafka topic. NATS always uses the receipts.process subject
+	DeadLetterQueue string // Also the Kafka topic for parked receipts. NATS always uses the receipts.failed subject
+
+	Prefetch int // How many unacked messages a subscriber may hold at once
+	Workers  int // How many messages a subscriber handles in parallel. Messages with the same Id are never handled in parallel
+}
+
+// UseQueueNames renames the queues before anything is declared. Empty names keep the defaults. NewPublisher and NewSubscriber
+// call it, anything else that touches the queues has to call it first
+func UseQueueNames(config BusConfig) {
+	if config.ReceiptsQueue != "" {
+		ReceiptsQueue = config.ReceiptsQueue
+	}
+	if config.DeadLetterQueue != "" {
+		DeadLetterQueue = config.DeadLetterQueue
+	}
+}
+
+// NewPublisher connects to the configured broker. The RabbitMQ publisher is a *RabbitMQPublisher, which also gives access to
+// the connection for the dead letter tools
+func NewPublisher(config BusConfig) (Publisher, error) {
+	UseQueueNames(config)
+	switch config.Kind {
+	case RabbitMQ:
+		return NewRabbitMQPublisher(Connect(config.RabbitMQURL)), nil
+	case NATS:
+		return NewNATSPublisher(config.NATSURL)
+	case Kafka:
+		return NewKafkaPublisher(config.KafkaBrokers)
+	case InProcess:
+		return nil, errInProcessOnly
+	}
+	return nil, fmt.Errorf("unknown message bus %q", config.Kind)
+}
+
+// NewSubscriber connects to the configured broker
+func NewSubscriber(config BusConfig) (Subscriber, error) {
+	UseQueueNames(config)
+	switch config.Kind {
+	case RabbitMQ:
+		return NewRabbitMQSubscriber(Connect(config.RabbitMQURL), config.Prefetch, config.Workers), nil
+	case NATS:
+		return NewNATSSubscriber(config.NATSURL, config.Prefetch, config.Workers)
+	case Kafka:
+		return NewKafkaSubscriber(config.KafkaBrokers)
+	case InProcess:
+		return nil, errInProcessOnly
+	}
+	return nil, fmt.Errorf("unknown message bus %q", config.Kind)
+}
+
+// messageContext makes the log lines about a message carry the request ID the server gave it
+func messageContext(ctx context.Context, msg Message) context.Context {
+	return logging.WithRequestID(ctx, msg.Headers[RequestIDHeader])
+}
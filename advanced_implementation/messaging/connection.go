@@ -0,0 +1,195 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"restGo/alerting"
+	"restGo/logging"
+)
+
+// Tests shorten the delays
+var (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// SetupFunc prepares what a process needs on a fresh connection: channels, publishers, consumers
+type SetupFunc func(conn *amqp.Connection) error
+
+// Connection keeps a RabbitMQ connection alive. When the broker goes away it reconnects with exponential backoff, declares the
+// topology again and re-runs every registered SetupFunc, so channels and consumers come back without restarting the process
+type Connection struct {
+	url string
+
+	mu        sync.RWMutex
+	conn      *amqp.Connection
+	setups    []SetupFunc
+	connected bool
+	closing   bool
+	blocked   string // Why RabbitMQ is holding back publishers (a memory or disk alarm), empty when it isn't
+}
+
+// Connect blocks until it has connected and declared the topology, retrying with backoff instead of giving up
+func Connect(url string) *Connection {
+	c := &Connection{url: url}
+	c.reconnect()
+	return c
+}
+
+// Current returns the live connection. It may be closed if a reconnect is in progress
+func (c *Connection) Current() *amqp.Connection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn
+}
+
+// Connected reports whether the connection is up and every setup has run on it
+func (c *Connection) Connected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connected
+}
+
+// Blocked returns why RabbitMQ is refusing to accept publishes, or an empty string if it isn't
+func (c *Connection) Blocked() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.blocked
+}
+
+// OnConnect runs setup on the current connection and again after every reconnect
+func (c *Connection) OnConnect(setup SetupFunc) error {
+	c.mu.Lock()
+	c.setups = append(c.setups, setup)
+	conn := c.conn
+	c.mu.Unlock()
+	return setup(conn)
+}
+
+// Close shuts the connection down for good
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	c.closing = true
+	c.connected = false
+	conn := c.conn
+	c.mu.Unlock()
+	return conn.Close()
+}
+
+func (c *Connection) reconnect() {
+	delay := minReconnectDelay
+	for {
+		c.mu.RLock()
+		closing := c.closing
+		c.mu.RUnlock()
+		if closing {
+			return
+		}
+		err := c.dial()
+		if err == nil {
+			return
+		}
+		// Jitter keeps the server and the consumer from hammering the broker in lockstep
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay)))
+		slog.Warn("Failed to connect to RabbitMQ, retrying", "retry_in", wait.Round(time.Millisecond), logging.Err(err))
+		time.Sleep(wait)
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func (c *Connection) dial() error {
+	conn, err := amqp.Dial(c.url)
+	if err != nil {
+		return err
+	}
+	if err := DeclareTopology(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	c.mu.Lock()
+	c.conn = conn
+	setups := append([]SetupFunc(nil), c.setups...)
+	c.mu.Unlock()
+	for _, setup := range setups {
+		if err := setup(conn); err != nil {
+			conn.Close()
+			return errors.Join(errors.New("setting up the connection again"), err)
+		}
+	}
+	c.mu.Lock()
+	c.connected = true
+	c.mu.Unlock()
+	slog.Info("Successfully connected to RabbitMQ")
+
+	blockings := conn.NotifyBlocked(make(chan amqp.Blocking, 1))
+	go func() {
+		for blocking := range blockings {
+			c.mu.Lock()
+			if blocking.Active {
+				slog.Warn("RabbitMQ is blocking publishers", "reason", blocking.Reason)
+				c.blocked = "blocked by RabbitMQ: " + blocking.Reason
+			} else {
+				slog.Info("RabbitMQ is accepting publishes again")
+				c.blocked = ""
+			}
+			c.mu.Unlock()
+		}
+	}()
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		reason, ok := <-closed
+		c.mu.Lock()
+		c.connected = false
+		c.blocked = ""
+		closing := c.closing
+		c.mu.Unlock()
+		if closing {
+			return
+		}
+		if ok {
+			alerting.Raise(context.Background(), alerting.ConnectionLost, "Lost the connection to RabbitMQ", "reason", reason)
+		}
+		c.reconnect()
+	}()
+	return nil
+}
+
+// KeepChannel opens a channel on every connection and runs setup on it. If only the channel is closed, e.g. by a channel-level
+// error, while the connection stays up, a new channel is opened and setup runs again
+func (c *Connection) KeepChannel(setup func(ch *amqp.Channel) error) error {
+	return c.OnConnect(func(conn *amqp.Connection) error {
+		return c.openChannel(conn, setup)
+	})
+}
+
+func (c *Connection) openChannel(conn *amqp.Connection, setup func(ch *amqp.Channel) error) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := setup(ch); err != nil {
+		ch.Close()
+		return err
+	}
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		reason, ok := <-closed
+		if !ok || conn.IsClosed() {
+			return // Closed on purpose, or the whole connection is gone and reconnect takes care of it
+		}
+		slog.Warn("RabbitMQ closed a channel, opening a new one", "reason", reason)
+		for !conn.IsClosed() {
+			if err := c.openChannel(conn, setup); err == nil {
+				return
+			}
+			time.Sleep(minReconnectDelay)
+		}
+	}()
+	return nil
+}
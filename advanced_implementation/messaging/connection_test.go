@@ -0,0 +1,119 @@
+package messaging
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func init() {
+	minReconnectDelay = 20 * time.Millisecond
+	maxReconnectDelay = 200 * time.Millisecond
+}
+
+// The queue the KeepChannel setup in these tests declares, so the broker can tell which channel is the kept one
+const keptQueue = "kept"
+
+// eventually fails the test unless ok becomes true within a few seconds
+func eventually(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// connectWithSetups connects to the broker and registers a connection setup and a channel setup that count their runs
+func connectWithSetups(t *testing.T, broker *fakeBroker) (*Connection, *atomic.Int32, *atomic.Int32) {
+	t.Helper()
+	conn := Connect(broker.URL())
+	t.Cleanup(func() { conn.Close() })
+	var connSetups, channelSetups atomic.Int32
+	err := conn.OnConnect(func(*amqp.Connection) error {
+		connSetups.Add(1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.KeepChannel(func(ch *amqp.Channel) error {
+		channelSetups.Add(1)
+		_, err := ch.QueueDeclare(keptQueue, true, false, false, false, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, &connSetups, &channelSetups
+}
+
+func TestConnectionReconnectsAfterDrop(t *testing.T) {
+	broker := newFakeBroker(t)
+	conn, connSetups, channelSetups := connectWithSetups(t, broker)
+	if !conn.Connected() || broker.handshakes() != 1 {
+		t.Fatalf("connected = %v after %d handshakes, want connected after 1", conn.Connected(), broker.handshakes())
+	}
+	first := conn.Current()
+
+	broker.dropConnections()
+	eventually(t, "the connection is back", func() bool {
+		return conn.Connected() && conn.Current() != first && broker.handshakes() == 2
+	})
+	if connSetups.Load() != 2 || channelSetups.Load() != 2 {
+		t.Errorf("setups ran %d and %d times, want both twice", connSetups.Load(), channelSetups.Load())
+	}
+	if declares := broker.declaresOf(keptQueue); len(declares) != 2 || declares[0].conn == declares[1].conn {
+		t.Errorf("kept channel declared %d times, want once on each connection", len(declares))
+	}
+	// The topology is declared again too, on the new connection
+	if declares := broker.declaresOf(ReceiptsQueue); len(declares) != 2 {
+		t.Errorf("%s declared %d times, want 2", ReceiptsQueue, len(declares))
+	}
+}
+
+func TestConnectionBacksOff(t *testing.T) {
+	broker := newFakeBroker(t)
+	broker.refuseNext(4)
+	start := time.Now()
+	conn := Connect(broker.URL()) // Blocks until the fifth attempt gets through
+	defer conn.Close()
+	if !conn.Connected() {
+		t.Fatal("not connected")
+	}
+
+	attempts := broker.attemptTimes()
+	if len(attempts) != 5 {
+		t.Fatalf("%d connection attempts, want 5", len(attempts))
+	}
+	// Each wait is between half and one and a half times the delay, which doubles from 20ms: 10-30, 20-60, 40-120, 80-240ms
+	first, last := attempts[1].Sub(attempts[0]), attempts[4].Sub(attempts[3])
+	if first < 10*time.Millisecond || last < 80*time.Millisecond || last <= first {
+		t.Errorf("waited %s before the second attempt and %s before the fifth, want the waits to grow from 10ms", first, last)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %s to connect, want the backoff capped", elapsed)
+	}
+}
+
+func TestKeepChannelReopensAfterChannelClose(t *testing.T) {
+	broker := newFakeBroker(t)
+	conn, connSetups, channelSetups := connectWithSetups(t, broker)
+
+	broker.closeChannel(keptQueue)
+	eventually(t, "a new channel is set up", func() bool {
+		return channelSetups.Load() == 2
+	})
+	declares := broker.declaresOf(keptQueue)
+	if len(declares) != 2 || declares[0].conn != declares[1].conn || declares[0].channel == declares[1].channel {
+		t.Errorf("kept channel declared on %+v, want a second channel on the same connection", declares)
+	}
+	// Only the channel was lost, so the connection and its setups are left alone
+	if connSetups.Load() != 1 || broker.handshakes() != 1 || !conn.Connected() {
+		t.Errorf("connection setup ran %d times after %d handshakes, want 1 and 1", connSetups.Load(), broker.handshakes())
+	}
+}
@@ -0,0 +1,157 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeadLetter is a receipt parked in DeadLetterQueue
+type DeadLetter struct {
+	Id         string          `json:"Id"`
+	Reason     string          `json:"reason"`
+	RetryCount int             `json:"retryCount"`
+	Receipt    json.RawMessage `json:"receipt"`
+}
+
+func newDeadLetter(d amqp.Delivery) DeadLetter {
+	deadLetter := DeadLetter{
+		Id:         d.MessageId,
+		Reason:     failureReason(d.Headers),
+		RetryCount: RetryCount(d.Headers),
+		Receipt:    json.RawMessage(d.Body),
+	}
+	if deadLetter.Id == "" {
+		// Receipts published before the server set a message Id still carry it in the body
+		var receipt struct {
+			Id string `json:"Id"`
+		}
+		json.Unmarshal(d.Body, &receipt)
+		deadLetter.Id = receipt.Id
+	}
+	if !json.Valid(d.Body) {
+		deadLetter.Receipt, _ = json.Marshal(string(d.Body))
+	}
+	return deadLetter
+}
+
+// The consumer records why it parked a receipt. Receipts rejected straight to the dead letter exchange only have the
+// x-death header RabbitMQ adds, so fall back to that
+func failureReason(headers amqp.Table) string {
+	if reason, ok := headers[FailureReasonHeader].(string); ok {
+		return reason
+	}
+	if deaths, ok := headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if death, ok := deaths[0].(amqp.Table); ok {
+			return fmt.Sprintf("%v by the broker from %v", death["reason"], death["queue"])
+		}
+	}
+	return "unknown"
+}
+
+// visitDeadLetters gets every parked receipt and calls fn on it. Receipts are held unacked while the queue is walked so none is
+// seen twice; fn returns true to ack (remove) one, and everything else goes back to the queue when the channel closes
+func visitDeadLetters(ctx context.Context, conn *amqp.Connection, fn func(ch *amqp.Channel, d amqp.Delivery) (bool, error)) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err // The caller gave up, everything not yet removed goes back to the queue
+		}
+		d, ok, err := ch.Get(DeadLetterQueue, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		remove, err := fn(ch, d)
+		if err != nil {
+			return err
+		}
+		if remove {
+			if err := d.Ack(false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// selected reports whether a dead letter is one of the requested Ids. No Ids selects all of them
+func selected(Ids []string, deadLetter DeadLetter) bool {
+	if len(Ids) == 0 {
+		return true
+	}
+	for _, Id := range Ids {
+		if Id == deadLetter.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// ListDeadLetters returns every parked receipt without removing any
+func ListDeadLetters(ctx context.Context, conn *amqp.Connection) ([]DeadLetter, error) {
+	deadLetters := []DeadLetter{}
+	err := visitDeadLetters(ctx, conn, func(_ *amqp.Channel, d amqp.Delivery) (bool, error) {
+		deadLetters = append(deadLetters, newDeadLetter(d))
+		return false, nil
+	})
+	return deadLetters, err
+}
+
+// ReplayDeadLetters moves the parked receipts with the given Ids (or all of them if there are none) back to ReceiptsQueue with
+// their retry count reset. It returns how many were replayed
+func ReplayDeadLetters(ctx context.Context, conn *amqp.Connection, Ids []string) (int, error) {
+	replayed := 0
+	confirming := false
+	err := visitDeadLetters(ctx, conn, func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		if !selected(Ids, newDeadLetter(d)) {
+			return false, nil
+		}
+		if !confirming {
+			if err := ch.Confirm(false); err != nil {
+				return false, err
+			}
+			confirming = true
+		}
+		headers := amqp.Table{}
+		for k, v := range d.Headers {
+			if k != RetryCountHeader && k != FailureReasonHeader && k != "x-death" {
+				headers[k] = v
+			}
+		}
+		err := publishConfirmed(ctx, ch, "", ReceiptsQueue, amqp.Publishing{
+			Headers:      headers,
+			ContentType:  d.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    d.MessageId,
+			Body:         d.Body,
+		})
+		if err != nil {
+			return false, fmt.Errorf("replaying receipt %s: %w", d.MessageId, err)
+		}
+		replayed++
+		return true, nil
+	})
+	return replayed, err
+}
+
+// PurgeDeadLetters deletes the parked receipts with the given Ids (or all of them if there are none). It returns how many
+// were deleted
+func PurgeDeadLetters(ctx context.Context, conn *amqp.Connection, Ids []string) (int, error) {
+	purged := 0
+	err := visitDeadLetters(ctx, conn, func(_ *amqp.Channel, d amqp.Delivery) (bool, error) {
+		if !selected(Ids, newDeadLetter(d)) {
+			return false, nil
+		}
+		purged++
+		return true, nil
+	})
+	return purged, err
+}
@@ -0,0 +1,162 @@
+package messaging
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// dialTopology connects to the broker with a plain amqp091 connection and declares the topology on it
+func dialTopology(t *testing.T, broker *fakeBroker) *amqp.Connection {
+	t.Helper()
+	conn, err := amqp.Dial(broker.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := DeclareTopology(conn); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// parkDeadLetters fills DeadLetterQueue with a receipt parked by the consumer after retrying, one rejected straight to the dead
+// letter exchange by the broker and one published before the server set message Ids
+func parkDeadLetters(t *testing.T, broker *fakeBroker) {
+	t.Helper()
+	broker.addQueue(DeadLetterQueue, true, nil,
+		amqp.Publishing{
+			MessageId: "parked",
+			Headers:   amqp.Table{RetryCountHeader: int32(3), FailureReasonHeader: "gave up after 3 retries: disk full", "x-request-id": "req-1"},
+			Body:      []byte(`{"Id":"parked","retailer":"Target"}`),
+		},
+		amqp.Publishing{
+			MessageId: "rejected",
+			Headers:   amqp.Table{"x-death": []interface{}{amqp.Table{"reason": "rejected", "queue": ReceiptsQueue}}},
+			Body:      []byte(`{"Id":"rejected"}`),
+		},
+		amqp.Publishing{Body: []byte(`{"Id":"old"}`)},
+	)
+}
+
+func TestListDeadLetters(t *testing.T) {
+	broker := newFakeBroker(t)
+	conn := dialTopology(t, broker)
+	parkDeadLetters(t, broker)
+
+	deadLetters, err := ListDeadLetters(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DeadLetter{
+		{Id: "parked", Reason: "gave up after 3 retries: disk full", RetryCount: 3, Receipt: []byte(`{"Id":"parked","retailer":"Target"}`)},
+		{Id: "rejected", Reason: "rejected by the broker from " + ReceiptsQueue, Receipt: []byte(`{"Id":"rejected"}`)},
+		{Id: "old", Reason: "unknown", Receipt: []byte(`{"Id":"old"}`)},
+	}
+	if len(deadLetters) != len(want) {
+		t.Fatalf("ListDeadLetters() = %+v, want %+v", deadLetters, want)
+	}
+	for i := range want {
+		got := deadLetters[i]
+		if got.Id != want[i].Id || got.Reason != want[i].Reason || got.RetryCount != want[i].RetryCount || string(got.Receipt) != string(want[i].Receipt) {
+			t.Errorf("dead letter %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+	// Listing leaves every receipt in the queue, in the same order
+	eventually(t, "the listed receipts are back in the queue", func() bool {
+		return slices.Equal(broker.messageIds(DeadLetterQueue), []string{"parked", "rejected", ""})
+	})
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	broker := newFakeBroker(t)
+	conn := dialTopology(t, broker)
+	parkDeadLetters(t, broker)
+
+	replayed, err := ReplayDeadLetters(context.Background(), conn, []string{"parked", "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 2 {
+		t.Errorf("ReplayDeadLetters() = %d, want 2", replayed)
+	}
+	eventually(t, "the receipt that wasn't replayed is back in the queue", func() bool {
+		return slices.Equal(broker.messageIds(DeadLetterQueue), []string{"rejected"})
+	})
+
+	receipts, _ := broker.queue(ReceiptsQueue)
+	if len(receipts.messages) != 2 {
+		t.Fatalf("%d receipts in %s, want 2", len(receipts.messages), ReceiptsQueue)
+	}
+	parked := receipts.messages[0]
+	if parked.props.MessageId != "parked" || string(parked.body) != `{"Id":"parked","retailer":"Target"}` || parked.props.DeliveryMode != amqp.Persistent {
+		t.Errorf("replayed %+v, want the parked receipt as persistent", parked)
+	}
+	// The receipt starts over, but keeps the headers that aren't about its failure
+	if len(parked.props.Headers) != 1 || parked.props.Headers["x-request-id"] != "req-1" {
+		t.Errorf("replayed with headers %v, want only x-request-id", parked.props.Headers)
+	}
+}
+
+func TestPurgeDeadLetters(t *testing.T) {
+	broker := newFakeBroker(t)
+	conn := dialTopology(t, broker)
+	parkDeadLetters(t, broker)
+
+	purged, err := PurgeDeadLetters(context.Background(), conn, []string{"rejected", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("PurgeDeadLetters() = %d for one known Id, want 1", purged)
+	}
+	eventually(t, "the other receipts are back in the queue", func() bool {
+		return slices.Equal(broker.messageIds(DeadLetterQueue), []string{"parked", ""})
+	})
+
+	// No Ids purges everything
+	purged, err = PurgeDeadLetters(context.Background(), conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 2 {
+		t.Errorf("PurgeDeadLetters() = %d for all, want 2", purged)
+	}
+	eventually(t, "the queue is empty", func() bool {
+		return len(broker.messageIds(DeadLetterQueue)) == 0 && broker.unacked(DeadLetterQueue) == 0
+	})
+	if receipts, _ := broker.queue(ReceiptsQueue); len(receipts.messages) != 0 {
+		t.Errorf("purging sent %d receipts to %s", len(receipts.messages), ReceiptsQueue)
+	}
+}
+
+func TestDeadLettersStopWhenCancelled(t *testing.T) {
+	broker := newFakeBroker(t)
+	conn := dialTopology(t, broker)
+	parkDeadLetters(t, broker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := PurgeDeadLetters(ctx, conn, nil); err == nil {
+		t.Error("PurgeDeadLetters() succeeded with a cancelled context")
+	}
+	if Ids := broker.messageIds(DeadLetterQueue); len(Ids) != 3 {
+		t.Errorf("%v left in the queue after a cancelled purge, want all 3", Ids)
+	}
+}
+
+func TestReplayKeepsDeadLetterTheBrokerRefuses(t *testing.T) {
+	broker := newFakeBroker(t)
+	conn := dialTopology(t, broker)
+	parkDeadLetters(t, broker)
+	broker.refusePublishes(true)
+
+	if _, err := ReplayDeadLetters(context.Background(), conn, []string{"parked"}); err == nil {
+		t.Error("ReplayDeadLetters() succeeded when the broker refused the copy")
+	}
+	eventually(t, "every receipt is back in the queue", func() bool {
+		return len(broker.messageIds(DeadLetterQueue)) == 3
+	})
+}
@@ -0,0 +1,1056 @@
+package messaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"maps"
+	"math"
+	"net"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeBroker speaks enough AMQP 0-9-1 for everything this package does with RabbitMQ: the handshake, channels, exchanges,
+// queues and bindings, publishing with confirms, consuming, basic.get, acks, nacks and rejects, message TTLs and dead
+// lettering. Tests use it to drop connections, close channels and hold back confirms the way RabbitMQ would
+type fakeBroker struct {
+	listener net.Listener
+
+	mu        sync.Mutex
+	conns     []*brokerConn
+	attempts  []time.Time // When each connection was made, including the ones hung up on
+	refuse    int         // How many of the next connections to hang up on before the handshake
+	opened    int         // Connections that got through the handshake
+	queues    map[string]*brokerQueue
+	exchanges map[string]*brokerExchange
+	declares  map[string][]declare
+	consumers map[string][]*brokerConsumer // By queue
+	next      int                          // Round robin over a queue's consumers
+
+	holdConfirms bool     // Confirms wait for releaseConfirms instead of going out straight away
+	held         []func() // The confirms being held
+	nackAll      bool     // Publishes are refused with a nack instead of stored
+}
+
+type declare struct {
+	conn    *brokerConn
+	channel uint16
+}
+
+type brokerConn struct {
+	net.Conn
+	mu       sync.Mutex // Serialises writes
+	channels map[uint16]*brokerChannel
+}
+
+type brokerChannel struct {
+	conn       *brokerConn
+	id         uint16
+	confirming bool
+	published  uint64 // Publish sequence number for confirms
+	nextTag    uint64
+	unacked    map[uint64]unackedMessage
+	publishing *incoming // Content of a basic.publish still arriving
+}
+
+type unackedMessage struct {
+	queue string
+	msg   *brokerMessage
+}
+
+type incoming struct {
+	exchange, key string
+	size          uint64
+	props         properties
+	body          []byte
+}
+
+type brokerConsumer struct {
+	channel *brokerChannel
+	tag     string
+}
+
+type brokerQueue struct {
+	durable  bool
+	args     amqp.Table
+	messages []*brokerMessage
+}
+
+type brokerExchange struct {
+	kind     string
+	bindings []binding
+}
+
+type binding struct {
+	queue, key string
+}
+
+// brokerMessage is a message as stored in a queue
+type brokerMessage struct {
+	exchange, key string
+	props         properties
+	body          []byte
+	redelivered   bool
+}
+
+// properties are the content header fields this package sets. Others are read and dropped
+type properties struct {
+	ContentType  string
+	Headers      amqp.Table
+	DeliveryMode uint8
+	MessageId    string
+	Timestamp    uint64
+}
+
+const frameEnd = 0xCE
+
+// AMQP class and method ids, as class<<16 | method
+const (
+	connectionStart    = 10<<16 | 10
+	connectionStartOk  = 10<<16 | 11
+	connectionTune     = 10<<16 | 30
+	connectionTuneOk   = 10<<16 | 31
+	connectionOpen     = 10<<16 | 40
+	connectionOpenOk   = 10<<16 | 41
+	connectionClose    = 10<<16 | 50
+	connectionCloseOk  = 10<<16 | 51
+	channelOpen        = 20<<16 | 10
+	channelOpenOk      = 20<<16 | 11
+	channelClose       = 20<<16 | 40
+	channelCloseOk     = 20<<16 | 41
+	exchangeDeclare    = 40<<16 | 10
+	exchangeDeclareOk  = 40<<16 | 11
+	queueDeclare       = 50<<16 | 10
+	queueDeclareOk     = 50<<16 | 11
+	queueBind          = 50<<16 | 20
+	queueBindOk        = 50<<16 | 21
+	queueDelete        = 50<<16 | 40
+	queueDeleteOk      = 50<<16 | 41
+	basicQos           = 60<<16 | 10
+	basicQosOk         = 60<<16 | 11
+	basicConsume       = 60<<16 | 20
+	basicConsumeOk     = 60<<16 | 21
+	basicCancel        = 60<<16 | 30
+	basicCancelOk      = 60<<16 | 31
+	basicPublish       = 60<<16 | 40
+	basicDeliver       = 60<<16 | 60
+	basicGet           = 60<<16 | 70
+	basicGetOk         = 60<<16 | 71
+	basicGetEmpty      = 60<<16 | 72
+	basicAck           = 60<<16 | 80
+	basicReject        = 60<<16 | 90
+	basicNack          = 60<<16 | 120
+	confirmSelect      = 85<<16 | 10
+	confirmSelectOk    = 85<<16 | 11
+	notFound           = 404
+	preconditionFailed = 406
+)
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{
+		listener:  listener,
+		queues:    map[string]*brokerQueue{},
+		exchanges: map[string]*brokerExchange{"": {kind: amqp.ExchangeDirect}},
+		declares:  map[string][]declare{},
+		consumers: map[string][]*brokerConsumer{},
+	}
+	go b.accept()
+	t.Cleanup(func() {
+		listener.Close()
+		b.dropConnections()
+	})
+	return b
+}
+
+func (b *fakeBroker) URL() string {
+	return "amqp://guest:guest@" + b.listener.Addr().String() + "/"
+}
+
+func (b *fakeBroker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.attempts = append(b.attempts, time.Now())
+		refused := b.refuse > 0
+		if refused {
+			b.refuse--
+		}
+		b.mu.Unlock()
+		if refused {
+			conn.Close()
+			continue
+		}
+		c := &brokerConn{Conn: conn, channels: map[uint16]*brokerChannel{}}
+		b.mu.Lock()
+		b.conns = append(b.conns, c)
+		b.mu.Unlock()
+		go b.serve(c)
+	}
+}
+
+// refuseNext hangs up on the next n connections, like a broker that is still starting
+func (b *fakeBroker) refuseNext(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refuse = n
+}
+
+// dropConnections cuts every connection without a word, like a broker that crashed or a network that failed. Unacked
+// messages go back to their queues
+func (b *fakeBroker) dropConnections() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.conns {
+		c.Close()
+		b.closeChannels(c)
+	}
+	b.conns = nil
+}
+
+// closeChannel closes the channel the queue was last declared on with a channel-level error, leaving the connection up
+func (b *fakeBroker) closeChannel(queue string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	last := b.declares[queue][len(b.declares[queue])-1]
+	b.channelError(last.conn.channels[last.channel], last.conn, last.channel, preconditionFailed, "PRECONDITION_FAILED - closed by the test", queueDeclare)
+}
+
+func (b *fakeBroker) handshakes() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.opened
+}
+
+func (b *fakeBroker) attemptTimes() []time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]time.Time(nil), b.attempts...)
+}
+
+// declaresOf returns the connections and channels the queue was declared on, in order
+func (b *fakeBroker) declaresOf(queue string) []declare {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]declare(nil), b.declares[queue]...)
+}
+
+// addQueue creates a queue with messages in it, like one left behind by an earlier version
+func (b *fakeBroker) addQueue(name string, durable bool, args amqp.Table, msgs ...amqp.Publishing) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := &brokerQueue{durable: durable, args: args}
+	b.queues[name] = q
+	for _, msg := range msgs {
+		q.messages = append(q.messages, &brokerMessage{
+			key:   name,
+			props: properties{ContentType: msg.ContentType, Headers: msg.Headers, DeliveryMode: msg.DeliveryMode, MessageId: msg.MessageId},
+			body:  msg.Body,
+		})
+	}
+}
+
+// publish routes a message as if a client had published it
+func (b *fakeBroker) publish(exchange, key string, msg amqp.Publishing) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.route(exchange, key, &brokerMessage{
+		exchange: exchange,
+		key:      key,
+		props:    properties{ContentType: msg.ContentType, Headers: msg.Headers, DeliveryMode: msg.DeliveryMode, MessageId: msg.MessageId},
+		body:     msg.Body,
+	})
+}
+
+// queue returns a copy of a queue's state and whether it exists
+func (b *fakeBroker) queue(name string) (brokerQueue, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[name]
+	if !ok {
+		return brokerQueue{}, false
+	}
+	copied := *q
+	copied.messages = nil
+	for _, msg := range q.messages {
+		m := *msg
+		copied.messages = append(copied.messages, &m)
+	}
+	return copied, true
+}
+
+// messageIds lists the Ids of the messages waiting in a queue
+func (b *fakeBroker) messageIds(name string) []string {
+	q, _ := b.queue(name)
+	Ids := []string{}
+	for _, msg := range q.messages {
+		Ids = append(Ids, msg.props.MessageId)
+	}
+	return Ids
+}
+
+// unacked counts the messages taken from a queue that nobody has settled yet
+func (b *fakeBroker) unacked(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	count := 0
+	for _, c := range b.conns {
+		for _, ch := range c.channels {
+			for _, u := range ch.unacked {
+				if u.queue == queue {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+// holdBackConfirms keeps publisher confirms from going out until releaseConfirms
+func (b *fakeBroker) holdBackConfirms() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.holdConfirms = true
+}
+
+func (b *fakeBroker) releaseConfirms() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.holdConfirms = false
+	for _, confirm := range b.held {
+		confirm()
+	}
+	b.held = nil
+}
+
+// refusePublishes makes the broker nack every publish, as RabbitMQ does when it can't store a message
+func (b *fakeBroker) refusePublishes(refuse bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nackAll = refuse
+}
+
+func (b *fakeBroker) serve(c *brokerConn) {
+	defer func() {
+		c.Close()
+		b.mu.Lock()
+		b.closeChannels(c)
+		b.mu.Unlock()
+	}()
+	r := bufio.NewReader(c)
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil || string(header) != "AMQP\x00\x00\x09\x01" {
+		return
+	}
+	var start bytes.Buffer
+	start.Write([]byte{0, 9})
+	binary.Write(&start, binary.BigEndian, uint32(0)) // No server properties
+	writeLongString(&start, "PLAIN")
+	writeLongString(&start, "en_US")
+	c.send(0, connectionStart, start.Bytes())
+
+	for {
+		kind, channel, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		closed := b.handleFrame(c, kind, channel, payload)
+		b.mu.Unlock()
+		if closed {
+			return
+		}
+	}
+}
+
+// handleFrame acts on one frame with the lock held. It returns true once the client has closed the connection
+func (b *fakeBroker) handleFrame(c *brokerConn, kind byte, channel uint16, payload []byte) bool {
+	ch := c.channels[channel]
+	switch kind {
+	case 1:
+	case 2: // Content header of a publish
+		if ch == nil || ch.publishing == nil {
+			return false
+		}
+		args := reader{data: payload[4:]} // Class and weight
+		ch.publishing.size = args.longlong()
+		ch.publishing.props = readProperties(&args)
+		if ch.publishing.size == 0 {
+			b.published(ch)
+		}
+		return false
+	case 3: // Content body of a publish
+		if ch == nil || ch.publishing == nil {
+			return false
+		}
+		ch.publishing.body = append(ch.publishing.body, payload...)
+		if uint64(len(ch.publishing.body)) >= ch.publishing.size {
+			b.published(ch)
+		}
+		return false
+	default:
+		return false // Heartbeats
+	}
+
+	method := binary.BigEndian.Uint32(payload)
+	args := reader{data: payload[4:]}
+	if ch == nil && channel != 0 && method != channelOpen {
+		return false // Sent before the client saw the channel close
+	}
+	switch method {
+	case connectionStartOk:
+		var tune bytes.Buffer
+		binary.Write(&tune, binary.BigEndian, uint16(2047))   // channel-max
+		binary.Write(&tune, binary.BigEndian, uint32(131072)) // frame-max
+		binary.Write(&tune, binary.BigEndian, uint16(0))      // heartbeat
+		c.send(0, connectionTune, tune.Bytes())
+	case connectionTuneOk:
+	case connectionOpen:
+		b.opened++
+		c.send(0, connectionOpenOk, []byte{0})
+	case connectionClose:
+		b.closeChannels(c)
+		c.send(0, connectionCloseOk, nil)
+		return true
+	case channelOpen:
+		c.channels[channel] = &brokerChannel{conn: c, id: channel, unacked: map[uint64]unackedMessage{}}
+		c.send(channel, channelOpenOk, []byte{0, 0, 0, 0})
+	case channelClose:
+		b.dropChannel(c, channel)
+		c.send(channel, channelCloseOk, nil)
+	case channelCloseOk:
+	case exchangeDeclare:
+		args.short()
+		name, kind := args.shortstr(), args.shortstr()
+		if _, ok := b.exchanges[name]; !ok {
+			b.exchanges[name] = &brokerExchange{kind: kind}
+		}
+		c.send(channel, exchangeDeclareOk, nil)
+	case queueDeclare:
+		args.short()
+		name := args.shortstr()
+		bits := args.octet()
+		passive, durable := bits&1 != 0, bits&2 != 0
+		arguments := args.table()
+		q, exists := b.queues[name]
+		switch {
+		case passive && !exists:
+			b.channelError(ch, c, channel, notFound, "NOT_FOUND - no queue '"+name+"'", queueDeclare)
+			return false
+		case !passive && exists && (q.durable != durable || !sameArgs(q.args, arguments)):
+			b.channelError(ch, c, channel, preconditionFailed, "PRECONDITION_FAILED - inequivalent arg for queue '"+name+"'", queueDeclare)
+			return false
+		case !passive && !exists:
+			q = &brokerQueue{durable: durable, args: arguments}
+			b.queues[name] = q
+		}
+		if !passive {
+			b.declares[name] = append(b.declares[name], declare{conn: c, channel: channel})
+		}
+		var ok bytes.Buffer
+		writeShortString(&ok, name)
+		binary.Write(&ok, binary.BigEndian, uint32(len(q.messages)))
+		binary.Write(&ok, binary.BigEndian, uint32(len(b.consumers[name])))
+		c.send(channel, queueDeclareOk, ok.Bytes())
+	case queueBind:
+		args.short()
+		queue, exchange, key := args.shortstr(), args.shortstr(), args.shortstr()
+		if e, ok := b.exchanges[exchange]; ok {
+			e.bindings = append(e.bindings, binding{queue: queue, key: key})
+		}
+		c.send(channel, queueBindOk, nil)
+	case queueDelete:
+		args.short()
+		name := args.shortstr()
+		ifEmpty := args.octet()&2 != 0
+		q, ok := b.queues[name]
+		if !ok {
+			c.send(channel, queueDeleteOk, []byte{0, 0, 0, 0})
+			return false
+		}
+		if ifEmpty && len(q.messages) > 0 {
+			b.channelError(ch, c, channel, preconditionFailed, "PRECONDITION_FAILED - queue '"+name+"' not empty", queueDelete)
+			return false
+		}
+		delete(b.queues, name)
+		delete(b.consumers, name)
+		var deleted bytes.Buffer
+		binary.Write(&deleted, binary.BigEndian, uint32(len(q.messages)))
+		c.send(channel, queueDeleteOk, deleted.Bytes())
+	case basicQos:
+		c.send(channel, basicQosOk, nil)
+	case confirmSelect:
+		ch.confirming = true
+		c.send(channel, confirmSelectOk, nil)
+	case basicConsume:
+		args.short()
+		queue, tag := args.shortstr(), args.shortstr()
+		if _, ok := b.queues[queue]; !ok {
+			b.channelError(ch, c, channel, notFound, "NOT_FOUND - no queue '"+queue+"'", basicConsume)
+			return false
+		}
+		b.consumers[queue] = append(b.consumers[queue], &brokerConsumer{channel: ch, tag: tag})
+		var ok bytes.Buffer
+		writeShortString(&ok, tag)
+		c.send(channel, basicConsumeOk, ok.Bytes())
+		b.dispatch(queue)
+	case basicCancel:
+		tag := args.shortstr()
+		b.cancel(ch, tag)
+		var ok bytes.Buffer
+		writeShortString(&ok, tag)
+		c.send(channel, basicCancelOk, ok.Bytes())
+	case basicPublish:
+		args.short()
+		ch.publishing = &incoming{exchange: args.shortstr(), key: args.shortstr()}
+	case basicGet:
+		args.short()
+		queue := args.shortstr()
+		q, ok := b.queues[queue]
+		if !ok {
+			b.channelError(ch, c, channel, notFound, "NOT_FOUND - no queue '"+queue+"'", basicGet)
+			return false
+		}
+		if len(q.messages) == 0 {
+			c.send(channel, basicGetEmpty, []byte{0})
+			return false
+		}
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		ch.nextTag++
+		ch.unacked[ch.nextTag] = unackedMessage{queue: queue, msg: msg}
+		var got bytes.Buffer
+		binary.Write(&got, binary.BigEndian, ch.nextTag)
+		got.WriteByte(boolByte(msg.redelivered))
+		writeShortString(&got, msg.exchange)
+		writeShortString(&got, msg.key)
+		binary.Write(&got, binary.BigEndian, uint32(len(q.messages)))
+		c.sendContent(channel, basicGetOk, got.Bytes(), msg)
+	case basicAck:
+		tag, multiple := args.longlong(), args.octet()&1 != 0
+		ch.settle(tag, multiple) // Gone for good
+	case basicNack:
+		tag := args.longlong()
+		bits := args.octet()
+		b.reject(ch.settle(tag, bits&1 != 0), bits&2 != 0)
+	case basicReject:
+		tag := args.longlong()
+		b.reject(ch.settle(tag, false), args.octet()&1 != 0)
+	}
+	return false
+}
+
+// settle takes the deliveries an ack, nack or reject is about off the channel
+func (ch *brokerChannel) settle(tag uint64, multiple bool) []unackedMessage {
+	var settled []unackedMessage
+	for t, u := range ch.unacked {
+		if t == tag || multiple && t < tag {
+			settled = append(settled, u)
+			delete(ch.unacked, t)
+		}
+	}
+	return settled
+}
+
+// reject requeues messages or dead-letters them, like RabbitMQ does for a nack or reject
+func (b *fakeBroker) reject(messages []unackedMessage, requeue bool) {
+	for _, u := range messages {
+		if requeue {
+			b.requeue(u)
+		} else {
+			b.deadLetter(u.queue, u.msg, "rejected")
+		}
+	}
+}
+
+func (b *fakeBroker) requeue(u unackedMessage) {
+	q, ok := b.queues[u.queue]
+	if !ok {
+		return
+	}
+	u.msg.redelivered = true
+	q.messages = append([]*brokerMessage{u.msg}, q.messages...)
+	b.dispatch(u.queue)
+}
+
+// published routes a message once all of its content has arrived, and confirms it if the channel is in confirm mode
+func (b *fakeBroker) published(ch *brokerChannel) {
+	in := ch.publishing
+	ch.publishing = nil
+	if !ch.confirming {
+		b.route(in.exchange, in.key, &brokerMessage{exchange: in.exchange, key: in.key, props: in.props, body: in.body})
+		return
+	}
+	ch.published++
+	tag := ch.published
+	method := uint32(basicAck)
+	if b.nackAll {
+		method = basicNack
+	} else {
+		b.route(in.exchange, in.key, &brokerMessage{exchange: in.exchange, key: in.key, props: in.props, body: in.body})
+	}
+	confirm := func() {
+		var args bytes.Buffer
+		binary.Write(&args, binary.BigEndian, tag)
+		args.WriteByte(0)
+		ch.conn.send(ch.id, method, args.Bytes())
+	}
+	if b.holdConfirms {
+		b.held = append(b.held, confirm)
+		return
+	}
+	confirm()
+}
+
+// route puts a copy of the message in every queue the exchange sends it to
+func (b *fakeBroker) route(exchange, key string, msg *brokerMessage) {
+	var queues []string
+	if exchange == "" {
+		queues = []string{key}
+	} else if e, ok := b.exchanges[exchange]; ok {
+		for _, bound := range e.bindings {
+			if e.kind == amqp.ExchangeFanout || bound.key == key {
+				queues = append(queues, bound.queue)
+			}
+		}
+	}
+	for _, name := range queues {
+		if _, ok := b.queues[name]; ok {
+			copied := *msg
+			b.enqueue(name, &copied)
+		}
+	}
+}
+
+func (b *fakeBroker) enqueue(name string, msg *brokerMessage) {
+	q := b.queues[name]
+	q.messages = append(q.messages, msg)
+	if ttl, ok := q.args["x-message-ttl"].(int64); ok {
+		time.AfterFunc(time.Duration(ttl)*time.Millisecond, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.expire(name, q, msg)
+		})
+	}
+	b.dispatch(name)
+}
+
+// expire dead-letters a message whose TTL ran out, if it is still waiting in the queue
+func (b *fakeBroker) expire(name string, q *brokerQueue, msg *brokerMessage) {
+	for i, waiting := range q.messages {
+		if waiting == msg {
+			q.messages = append(q.messages[:i:i], q.messages[i+1:]...)
+			b.deadLetter(name, msg, "expired")
+			return
+		}
+	}
+}
+
+// deadLetter sends a message to the queue's dead letter exchange with an x-death header, or drops it if there is none
+func (b *fakeBroker) deadLetter(name string, msg *brokerMessage, reason string) {
+	q, ok := b.queues[name]
+	if !ok {
+		return
+	}
+	exchange, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	key := msg.key
+	if routingKey, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = routingKey
+	}
+	headers := amqp.Table{}
+	for k, v := range msg.props.Headers {
+		headers[k] = v
+	}
+	headers["x-death"] = []interface{}{amqp.Table{"reason": reason, "queue": name, "count": int64(1), "exchange": msg.exchange}}
+	dead := &brokerMessage{exchange: exchange, key: key, props: msg.props, body: msg.body}
+	dead.props.Headers = headers
+	b.route(exchange, key, dead)
+}
+
+// dispatch hands waiting messages to the queue's consumers in turn
+func (b *fakeBroker) dispatch(name string) {
+	q, ok := b.queues[name]
+	for ok && len(q.messages) > 0 && len(b.consumers[name]) > 0 {
+		consumers := b.consumers[name]
+		b.next = (b.next + 1) % len(consumers)
+		consumer := consumers[b.next]
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		ch := consumer.channel
+		ch.nextTag++
+		ch.unacked[ch.nextTag] = unackedMessage{queue: name, msg: msg}
+		var args bytes.Buffer
+		writeShortString(&args, consumer.tag)
+		binary.Write(&args, binary.BigEndian, ch.nextTag)
+		args.WriteByte(boolByte(msg.redelivered))
+		writeShortString(&args, msg.exchange)
+		writeShortString(&args, msg.key)
+		ch.conn.sendContent(ch.id, basicDeliver, args.Bytes(), msg)
+	}
+}
+
+func (b *fakeBroker) cancel(ch *brokerChannel, tag string) {
+	for queue, consumers := range b.consumers {
+		kept := consumers[:0:0]
+		for _, consumer := range consumers {
+			if consumer.channel != ch || tag != "" && consumer.tag != tag {
+				kept = append(kept, consumer)
+			}
+		}
+		b.consumers[queue] = kept
+	}
+}
+
+// dropChannel forgets a channel's consumers and requeues what it hadn't settled, as RabbitMQ does
+func (b *fakeBroker) dropChannel(c *brokerConn, id uint16) {
+	ch, ok := c.channels[id]
+	if !ok {
+		return
+	}
+	delete(c.channels, id)
+	b.cancel(ch, "")
+	// Back at the front of their queues in the order they were taken, the last one first
+	tags := slices.Sorted(maps.Keys(ch.unacked))
+	slices.Reverse(tags)
+	for _, tag := range tags {
+		b.requeue(ch.unacked[tag])
+	}
+}
+
+func (b *fakeBroker) closeChannels(c *brokerConn) {
+	for id := range c.channels {
+		b.dropChannel(c, id)
+	}
+}
+
+// channelError closes a channel from the broker's side, like RabbitMQ does after a failed declare or delete
+func (b *fakeBroker) channelError(ch *brokerChannel, c *brokerConn, id uint16, code uint16, text string, method uint32) {
+	if ch != nil {
+		b.dropChannel(c, id)
+	}
+	var args bytes.Buffer
+	binary.Write(&args, binary.BigEndian, code)
+	writeShortString(&args, text)
+	binary.Write(&args, binary.BigEndian, method)
+	c.send(id, channelClose, args.Bytes())
+}
+
+// sameArgs compares queue arguments like RabbitMQ does, where no arguments and an empty table are the same
+func sameArgs(a, b amqp.Table) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c *brokerConn) send(channel uint16, method uint32, args []byte) {
+	var frame bytes.Buffer
+	writeMethodFrame(&frame, channel, method, args)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Write(frame.Bytes())
+}
+
+// sendContent sends a method that carries a message, with its content header and body, in one write so nothing comes between
+func (c *brokerConn) sendContent(channel uint16, method uint32, args []byte, msg *brokerMessage) {
+	var frames bytes.Buffer
+	writeMethodFrame(&frames, channel, method, args)
+
+	var header bytes.Buffer
+	binary.Write(&header, binary.BigEndian, uint16(60)) // Basic class
+	binary.Write(&header, binary.BigEndian, uint16(0))  // Weight
+	binary.Write(&header, binary.BigEndian, uint64(len(msg.body)))
+	writeProperties(&header, msg.props)
+	writeFrame(&frames, 2, channel, header.Bytes())
+	if len(msg.body) > 0 {
+		writeFrame(&frames, 3, channel, msg.body)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Write(frames.Bytes())
+}
+
+func writeMethodFrame(w *bytes.Buffer, channel uint16, method uint32, args []byte) {
+	payload := binary.BigEndian.AppendUint32(nil, method)
+	writeFrame(w, 1, channel, append(payload, args...))
+}
+
+func writeFrame(w *bytes.Buffer, kind byte, channel uint16, payload []byte) {
+	w.WriteByte(kind)
+	binary.Write(w, binary.BigEndian, channel)
+	binary.Write(w, binary.BigEndian, uint32(len(payload)))
+	w.Write(payload)
+	w.WriteByte(frameEnd)
+}
+
+func readFrame(r *bufio.Reader) (byte, uint16, []byte, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[3:])+1) // With the frame end
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return header[0], binary.BigEndian.Uint16(header[1:]), payload[:len(payload)-1], nil
+}
+
+// Content header property flags
+const (
+	flagContentType     = 0x8000
+	flagContentEncoding = 0x4000
+	flagHeaders         = 0x2000
+	flagDeliveryMode    = 0x1000
+	flagPriority        = 0x0800
+	flagCorrelationId   = 0x0400
+	flagReplyTo         = 0x0200
+	flagExpiration      = 0x0100
+	flagMessageId       = 0x0080
+	flagTimestamp       = 0x0040
+	flagType            = 0x0020
+	flagUserId          = 0x0010
+	flagAppId           = 0x0008
+)
+
+func readProperties(r *reader) properties {
+	var props properties
+	flags := r.short()
+	for _, flag := range []uint16{flagContentType, flagContentEncoding, flagHeaders, flagDeliveryMode, flagPriority, flagCorrelationId,
+		flagReplyTo, flagExpiration, flagMessageId, flagTimestamp, flagType, flagUserId, flagAppId} {
+		if flags&flag == 0 {
+			continue
+		}
+		switch flag {
+		case flagContentType:
+			props.ContentType = r.shortstr()
+		case flagHeaders:
+			props.Headers = r.table()
+		case flagDeliveryMode:
+			props.DeliveryMode = r.octet()
+		case flagPriority:
+			r.octet()
+		case flagMessageId:
+			props.MessageId = r.shortstr()
+		case flagTimestamp:
+			props.Timestamp = r.longlong()
+		default:
+			r.shortstr()
+		}
+	}
+	return props
+}
+
+func writeProperties(w *bytes.Buffer, props properties) {
+	var flags uint16
+	var fields bytes.Buffer
+	if props.ContentType != "" {
+		flags |= flagContentType
+		writeShortString(&fields, props.ContentType)
+	}
+	if len(props.Headers) > 0 {
+		flags |= flagHeaders
+		writeTable(&fields, props.Headers)
+	}
+	if props.DeliveryMode != 0 {
+		flags |= flagDeliveryMode
+		fields.WriteByte(props.DeliveryMode)
+	}
+	if props.MessageId != "" {
+		flags |= flagMessageId
+		writeShortString(&fields, props.MessageId)
+	}
+	if props.Timestamp != 0 {
+		flags |= flagTimestamp
+		binary.Write(&fields, binary.BigEndian, props.Timestamp)
+	}
+	binary.Write(w, binary.BigEndian, flags)
+	w.Write(fields.Bytes())
+}
+
+// reader reads the fields of a method or content header. The client is trusted to send well formed frames
+type reader struct {
+	data []byte
+}
+
+func (r *reader) octet() byte {
+	v := r.data[0]
+	r.data = r.data[1:]
+	return v
+}
+
+func (r *reader) short() uint16 {
+	v := binary.BigEndian.Uint16(r.data)
+	r.data = r.data[2:]
+	return v
+}
+
+func (r *reader) long() uint32 {
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+func (r *reader) longlong() uint64 {
+	v := binary.BigEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v
+}
+
+func (r *reader) shortstr() string {
+	n := int(r.octet())
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+
+func (r *reader) longstr() string {
+	n := int(r.long())
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+
+// table reads a field table into the same Go types amqp091 uses
+func (r *reader) table() amqp.Table {
+	nested := reader{data: []byte(r.longstr())}
+	table := amqp.Table{}
+	for len(nested.data) > 0 {
+		key := nested.shortstr()
+		table[key] = nested.field()
+	}
+	return table
+}
+
+func (r *reader) field() interface{} {
+	switch r.octet() {
+	case 't':
+		return r.octet() != 0
+	case 'B':
+		return r.octet()
+	case 'b':
+		return int8(r.octet())
+	case 's':
+		return int16(r.short())
+	case 'I':
+		return int32(r.long())
+	case 'l':
+		return int64(r.longlong())
+	case 'f':
+		return math.Float32frombits(r.long())
+	case 'd':
+		return math.Float64frombits(r.longlong())
+	case 'S':
+		return r.longstr()
+	case 'A':
+		array := reader{data: []byte(r.longstr())}
+		var values []interface{}
+		for len(array.data) > 0 {
+			values = append(values, array.field())
+		}
+		return values
+	case 'T':
+		return time.Unix(int64(r.longlong()), 0)
+	case 'F':
+		return r.table()
+	case 'x':
+		return []byte(r.longstr())
+	}
+	return nil // 'V'
+}
+
+func writeShortString(w *bytes.Buffer, s string) {
+	w.WriteByte(byte(len(s)))
+	w.WriteString(s)
+}
+
+func writeLongString(w *bytes.Buffer, s string) {
+	binary.Write(w, binary.BigEndian, uint32(len(s)))
+	w.WriteString(s)
+}
+
+func writeTable(w *bytes.Buffer, table amqp.Table) {
+	var fields bytes.Buffer
+	for k, v := range table {
+		writeShortString(&fields, k)
+		writeField(&fields, v)
+	}
+	writeLongString(w, fields.String())
+}
+
+// writeField writes a value the way amqp091 does, so the client reads back the type it sent
+func writeField(w *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case bool:
+		w.WriteByte('t')
+		w.WriteByte(boolByte(v))
+	case byte:
+		w.WriteByte('B')
+		w.WriteByte(v)
+	case int8:
+		w.WriteByte('b')
+		w.WriteByte(byte(v))
+	case int16:
+		w.WriteByte('s')
+		binary.Write(w, binary.BigEndian, v)
+	case int:
+		w.WriteByte('I')
+		binary.Write(w, binary.BigEndian, int32(v))
+	case int32:
+		w.WriteByte('I')
+		binary.Write(w, binary.BigEndian, v)
+	case int64:
+		w.WriteByte('l')
+		binary.Write(w, binary.BigEndian, v)
+	case float32:
+		w.WriteByte('f')
+		binary.Write(w, binary.BigEndian, math.Float32bits(v))
+	case float64:
+		w.WriteByte('d')
+		binary.Write(w, binary.BigEndian, math.Float64bits(v))
+	case string:
+		w.WriteByte('S')
+		writeLongString(w, v)
+	case []interface{}:
+		var values bytes.Buffer
+		for _, value := range v {
+			writeField(&values, value)
+		}
+		w.WriteByte('A')
+		writeLongString(w, values.String())
+	case time.Time:
+		w.WriteByte('T')
+		binary.Write(w, binary.BigEndian, v.Unix())
+	case amqp.Table:
+		w.WriteByte('F')
+		writeTable(w, v)
+	case []byte:
+		w.WriteByte('x')
+		writeLongString(w, string(v))
+	default:
+		w.WriteByte('V')
+	}
+}
@@ -0,0 +1,54 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// HealthReporter is implemented by the publishers and subscribers that keep a connection to the broker. The Kafka clients dial
+// the brokers as they need them, so they have nothing to report until a publish or fetch fails
+type HealthReporter interface {
+	// Healthy returns nil while the broker can take receipts, and otherwise why it can't
+	Healthy(ctx context.Context) error
+}
+
+var errRabbitMQDown = errors.New("not connected to RabbitMQ, reconnecting")
+
+func (p *RabbitMQPublisher) Healthy(ctx context.Context) error {
+	if !p.conn.Connected() {
+		return errRabbitMQDown
+	}
+	if reason := p.conn.Blocked(); reason != "" {
+		return fmt.Errorf("RabbitMQ is blocking publishers: %s", reason)
+	}
+	return nil
+}
+
+func (s *RabbitMQSubscriber) Healthy(ctx context.Context) error {
+	if !s.conn.Connected() {
+		return errRabbitMQDown
+	}
+	return nil
+}
+
+func natsHealthy(nc *nats.Conn) error {
+	if status := nc.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("NATS connection is %s", status)
+	}
+	return nil
+}
+
+func (p *NATSPublisher) Healthy(ctx context.Context) error {
+	return natsHealthy(p.nc)
+}
+
+func (s *NATSSubscriber) Healthy(ctx context.Context) error {
+	return natsHealthy(s.nc)
+}
+
+func (b *InProcessBus) Healthy(ctx context.Context) error {
+	return nil
+}
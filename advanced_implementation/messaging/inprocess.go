@@ -0,0 +1,140 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"restGo/alerting"
+	"restGo/logging"
+	"restGo/tracing"
+)
+
+var errBusClosed = errors.New("the message bus is closed")
+
+type inProcessMessage struct {
+	Message
+	retryCount int
+}
+
+// InProcessBus is both a Publisher and a Subscriber, backed by a buffered Go channel. Nothing survives a restart, so it is only
+// meant for tests that run the server's handlers and the consumer's pipeline together in one process
+type InProcessBus struct {
+	// RetryTiers defaults to the package's, tests can shorten the delays
+	RetryTiers []RetryTier
+	Workers    int // Set before calling Subscribe
+
+	messages  chan inProcessMessage
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu          sync.Mutex
+	deadLetters []DeadLetter
+}
+
+func NewInProcessBus(buffer int) *InProcessBus {
+	return &InProcessBus{
+		RetryTiers: RetryTiers,
+		Workers:    1,
+		messages:   make(chan inProcessMessage, buffer),
+		done:       make(chan struct{}),
+	}
+}
+
+// Publish blocks while the buffer is full
+func (b *InProcessBus) Publish(ctx context.Context, msg Message) error {
+	ctx, msg, span := startPublish(ctx, InProcess, ReceiptsQueue, msg)
+	err := b.enqueue(ctx, inProcessMessage{Message: msg})
+	tracing.End(span, err)
+	return err
+}
+
+func (b *InProcessBus) enqueue(ctx context.Context, msg inProcessMessage) error {
+	select {
+	case <-b.done:
+		return errBusClosed
+	default:
+	}
+	select {
+	case b.messages <- msg:
+		return nil
+	case <-b.done:
+		return errBusClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Subscribe handles messages on Workers goroutines until ctx is done or the bus is closed
+func (b *InProcessBus) Subscribe(ctx context.Context, handler Handler, ready func()) error {
+	pool := newWorkerPool(b.Workers)
+	defer pool.stop()
+	work := context.WithoutCancel(ctx)
+	ready()
+	for {
+		select {
+		case msg := <-b.messages:
+			pool.dispatch(msg.Id, func() {
+				b.handle(work, msg, handler)
+			})
+		case <-b.done:
+			return errBusClosed
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (b *InProcessBus) handle(ctx context.Context, msg inProcessMessage, handler Handler) {
+	ctx, span := startProcess(ctx, InProcess, ReceiptsQueue, msg.Message)
+	defer span.End()
+	err := handler(ctx, msg.Message)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrPoison):
+		b.park(msg, err.Error())
+	case msg.retryCount < len(b.RetryTiers):
+		tier := b.RetryTiers[msg.retryCount]
+		retriesMetric.Inc()
+		slog.InfoContext(ctx, "Retrying receipt", logging.Receipt(msg.Id), "retry_in", tier.Delay, logging.Err(err))
+		msg.retryCount++
+		time.AfterFunc(tier.Delay, func() {
+			if err := b.enqueue(context.Background(), msg); err != nil {
+				b.park(msg, err.Error())
+			}
+		})
+	default:
+		b.park(msg, fmt.Sprintf("gave up after %d retries: %v", msg.retryCount, err))
+	}
+}
+
+func (b *InProcessBus) park(msg inProcessMessage, reason string) {
+	deadLettersMetric.Inc()
+	alerting.Raise(messageContext(context.Background(), msg.Message), alerting.DeadLettered, "Parking receipt in the dead letters", logging.Receipt(msg.Id), "reason", reason)
+	receipt := json.RawMessage(msg.Body)
+	if !json.Valid(msg.Body) {
+		receipt, _ = json.Marshal(string(msg.Body))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deadLetters = append(b.deadLetters, DeadLetter{Id: msg.Id, Reason: reason, RetryCount: msg.retryCount, Receipt: receipt})
+}
+
+// DeadLetters returns a copy of every message parked so far
+func (b *InProcessBus) DeadLetters() []DeadLetter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]DeadLetter{}, b.deadLetters...)
+}
+
+// Close stops every subscriber. Messages still buffered are dropped
+func (b *InProcessBus) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+	return nil
+}
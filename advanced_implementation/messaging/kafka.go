@@ -0,0 +1,152 @@
+//go:build kafka
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"restGo/alerting"
+	"restGo/logging"
+	"restGo/tracing"
+)
+
+// Topics reuse the RabbitMQ queue names
+const kafkaConsumerGroup = "receipts-consumer"
+
+func newKafkaWriter(brokers []string, topic string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Topic:                  topic,
+		Balancer:               &kafka.Hash{},    // Keyed by receipt Id, so every copy of a receipt lands on the same partition
+		RequiredAcks:           kafka.RequireAll, // Only count a receipt as published once every in-sync replica has it
+		BatchTimeout:           10 * time.Millisecond,
+		AllowAutoTopicCreation: true,
+	}
+}
+
+func kafkaMessage(msg Message) kafka.Message {
+	kafkaMsg := kafka.Message{Key: []byte(msg.Id), Value: msg.Body}
+	for k, v := range msg.Headers {
+		kafkaMsg.Headers = append(kafkaMsg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	return kafkaMsg
+}
+
+// KafkaPublisher publishes receipts to the receipts topic
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func NewKafkaPublisher(brokers []string) (Publisher, error) {
+	return &KafkaPublisher{writer: newKafkaWriter(brokers, ReceiptsQueue)}, nil
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	ctx, msg, span := startPublish(ctx, Kafka, ReceiptsQueue, msg)
+	err := p.writer.WriteMessages(ctx, kafkaMessage(msg))
+	tracing.End(span, err)
+	return err
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// KafkaSubscriber reads the receipts topic as part of a consumer group. Kafka keeps a partition in order, so a transient failure
+// is retried in place after each of the RetryTiers delays, holding up the receipts behind it, before the receipt is copied to the
+// dead letter topic. An offset is only committed once its receipt is settled
+type KafkaSubscriber struct {
+	reader     *kafka.Reader
+	deadLetter *kafka.Writer
+}
+
+func NewKafkaSubscriber(brokers []string) (Subscriber, error) {
+	return &KafkaSubscriber{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: brokers,
+			GroupID: kafkaConsumerGroup,
+			Topic:   ReceiptsQueue,
+		}),
+		deadLetter: newKafkaWriter(brokers, DeadLetterQueue),
+	}, nil
+}
+
+// The reader joins the consumer group on its first fetch and kafka-go doesn't say when it has, so ready is called just before it
+func (s *KafkaSubscriber) Subscribe(ctx context.Context, handler Handler, ready func()) error {
+	ready()
+	for {
+		kafkaMsg, err := s.reader.FetchMessage(ctx)
+		if ctx.Err() != nil {
+			return nil // Receipts are handled one at a time, so there is nothing left to drain
+		}
+		if err != nil {
+			return err
+		}
+		msg := Message{Id: string(kafkaMsg.Key), Body: kafkaMsg.Value, Headers: map[string]string{}}
+		for _, header := range kafkaMsg.Headers {
+			msg.Headers[header.Key] = string(header.Value)
+		}
+		msgCtx, span := startProcess(ctx, Kafka, ReceiptsQueue, msg)
+		err = s.handle(msgCtx, msg, handler)
+		span.End()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil // Stopped while waiting to retry. Not committed, so the group picks it up again
+			}
+			return err // Not committed, so the group picks it up again
+		}
+		if err := s.reader.CommitMessages(context.WithoutCancel(ctx), kafkaMsg); err != nil {
+			// The handler is done with it, so the redelivery that follows a lost commit has to be harmless
+			slog.WarnContext(msgCtx, "Failed to commit receipt, it will be redelivered", logging.Receipt(msg.Id), logging.Err(err))
+		}
+	}
+}
+
+// handle returns an error only if the receipt could neither be processed nor parked
+func (s *KafkaSubscriber) handle(ctx context.Context, msg Message, handler Handler) error {
+	for retryCount := 0; ; retryCount++ {
+		err := handler(context.WithoutCancel(ctx), msg)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrPoison) {
+			return s.park(ctx, msg, err.Error())
+		}
+		tier, ok := NextRetryTier(retryCount)
+		if !ok {
+			return s.park(ctx, msg, fmt.Sprintf("gave up after %d retries: %v", retryCount, err))
+		}
+		retriesMetric.Inc()
+		slog.InfoContext(ctx, "Retrying receipt", logging.Receipt(msg.Id), "retry_in", tier.Delay, logging.Err(err))
+		select {
+		case <-time.After(tier.Delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *KafkaSubscriber) park(ctx context.Context, msg Message, reason string) error {
+	deadLettersMetric.Inc()
+	// Send an alert to the admin/monitoring system as well
+	alerting.Raise(ctx, alerting.DeadLettered, "Parking receipt on the dead letter topic", logging.Receipt(msg.Id), "topic", DeadLetterQueue, "reason", reason)
+	msg.Headers[FailureReasonHeader] = reason
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := s.deadLetter.WriteMessages(ctx, kafkaMessage(msg)); err != nil {
+		alerting.Raise(ctx, alerting.DeadLettered, "Error while saving receipt to the dead letter topic", logging.Receipt(msg.Id), logging.Err(err))
+		return err
+	}
+	return nil
+}
+
+func (s *KafkaSubscriber) Close() error {
+	return errors.Join(s.reader.Close(), s.deadLetter.Close())
+}
@@ -0,0 +1,16 @@
+//go:build !kafka
+
+package messaging
+
+import "errors"
+
+// The Kafka client pulls in a lot of compression libraries, so it is only built with -tags kafka
+var errKafkaNotBuilt = errors.New("built without Kafka support, rebuild with -tags kafka")
+
+func NewKafkaPublisher(brokers []string) (Publisher, error) {
+	return nil, errKafkaNotBuilt
+}
+
+func NewKafkaSubscriber(brokers []string) (Subscriber, error) {
+	return nil, errKafkaNotBuilt
+}
@@ -0,0 +1,18 @@
+package messaging
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Counted the same way by every backend, and exposed on /metrics by the consumer
+var (
+	retriesMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "receipts_retries_total",
+		Help: "Receipts that failed with a transient error and were scheduled to be tried again",
+	})
+	deadLettersMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "receipts_dead_lettered_total",
+		Help: "Receipts the consumer gave up on and parked in the dead letter queue",
+	})
+)
@@ -0,0 +1,193 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"restGo/alerting"
+	"restGo/logging"
+	"restGo/tracing"
+)
+
+// Receipts are kept in a JetStream stream on disk, so they survive a NATS restart just like the durable RabbitMQ queues. The
+// subjects are fixed, ReceiptsQueue and DeadLetterQueue only name the RabbitMQ queues and Kafka topics
+const (
+	natsStream            = "RECEIPTS"
+	natsReceiptsSubject   = "receipts.process"
+	natsDeadLetterSubject = "receipts.failed" // Kept in the same stream, nothing consumes it
+	natsConsumer          = "receipts-consumer"
+)
+
+// connectJetStream connects to NATS and makes sure the stream exists. Like Connect it retries until the server is up, and the
+// client reconnects on its own after that
+func connectJetStream(url string) (*nats.Conn, jetstream.JetStream, error) {
+	nc, err := nats.Connect(url, nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, nil, err
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+	delay := minReconnectDelay
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+			Name:       natsStream,
+			Subjects:   []string{natsReceiptsSubject, natsDeadLetterSubject},
+			Storage:    jetstream.FileStorage,
+			Duplicates: 2 * time.Minute, // A receipt published twice with the same Id in this window is only stored once
+		})
+		cancel()
+		if err == nil {
+			slog.Info("Successfully connected to NATS")
+			return nc, js, nil
+		}
+		slog.Warn("Failed to set up the NATS stream, retrying", "retry_in", delay, logging.Err(err))
+		time.Sleep(delay)
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func natsMessage(subject string, msg Message) *nats.Msg {
+	natsMsg := nats.NewMsg(subject)
+	natsMsg.Data = msg.Body
+	for k, v := range msg.Headers {
+		natsMsg.Header.Set(k, v)
+	}
+	return natsMsg
+}
+
+// NATSPublisher publishes receipts to JetStream. A publish only succeeds once the stream has stored the receipt
+type NATSPublisher struct {
+	nc *nats.Conn
+	js jetstream.JetStream
+}
+
+func NewNATSPublisher(url string) (*NATSPublisher, error) {
+	nc, js, err := connectJetStream(url)
+	if err != nil {
+		return nil, err
+	}
+	return &NATSPublisher{nc: nc, js: js}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	ctx, msg, span := startPublish(ctx, NATS, natsReceiptsSubject, msg)
+	_, err := p.js.PublishMsg(ctx, natsMessage(natsReceiptsSubject, msg), jetstream.WithMsgID(msg.Id))
+	tracing.End(span, err)
+	return err
+}
+
+func (p *NATSPublisher) Close() error {
+	p.nc.Close()
+	return nil
+}
+
+// NATSSubscriber consumes receipts through a durable JetStream consumer. Transient failures are redelivered after each of the
+// RetryTiers delays, and poison messages are copied to the dead letter subject and terminated
+type NATSSubscriber struct {
+	nc       *nats.Conn
+	js       jetstream.JetStream
+	prefetch int
+	workers  int
+}
+
+func NewNATSSubscriber(url string, prefetch, workers int) (*NATSSubscriber, error) {
+	nc, js, err := connectJetStream(url)
+	if err != nil {
+		return nil, err
+	}
+	return &NATSSubscriber{nc: nc, js: js, prefetch: prefetch, workers: workers}, nil
+}
+
+func (s *NATSSubscriber) Subscribe(ctx context.Context, handler Handler, ready func()) error {
+	consumer, err := s.js.CreateOrUpdateConsumer(ctx, natsStream, jetstream.ConsumerConfig{
+		Durable:       natsConsumer,
+		FilterSubject: natsReceiptsSubject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MaxAckPending: s.prefetch,
+	})
+	if err != nil {
+		return err
+	}
+	pool := newWorkerPool(s.workers)
+	defer pool.stop()
+	work := context.WithoutCancel(ctx)
+	consuming, err := consumer.Consume(func(m jetstream.Msg) {
+		pool.dispatch(m.Headers().Get(nats.MsgIdHdr), func() {
+			s.handle(work, m, handler)
+		})
+	}, jetstream.PullMaxMessages(s.prefetch))
+	if err != nil {
+		return err
+	}
+	ready()
+	<-ctx.Done()
+	consuming.Stop() // Messages pulled but not handled are redelivered once their ack wait runs out
+	return nil
+}
+
+func (s *NATSSubscriber) Close() error {
+	s.nc.Close()
+	return nil
+}
+
+// handle runs the handler on one message and settles it exactly once: ack, redeliver later or park
+func (s *NATSSubscriber) handle(ctx context.Context, m jetstream.Msg, handler Handler) {
+	msg := Message{Id: m.Headers().Get(nats.MsgIdHdr), Body: m.Data(), Headers: map[string]string{}}
+	for k := range m.Headers() {
+		msg.Headers[k] = m.Headers().Get(k)
+	}
+	ctx, span := startProcess(ctx, NATS, natsReceiptsSubject, msg)
+	defer span.End()
+	err := handler(ctx, msg)
+	if err == nil {
+		if err := m.Ack(); err != nil {
+			slog.WarnContext(ctx, "Failed to ack receipt, it will be redelivered", logging.Receipt(msg.Id), logging.Err(err))
+		}
+		return
+	}
+	if errors.Is(err, ErrPoison) {
+		s.park(ctx, m, msg, err.Error())
+		return
+	}
+	retryCount := 0
+	if meta, metaErr := m.Metadata(); metaErr == nil {
+		retryCount = int(meta.NumDelivered) - 1
+	}
+	tier, ok := NextRetryTier(retryCount)
+	if !ok {
+		s.park(ctx, m, msg, fmt.Sprintf("gave up after %d retries: %v", retryCount, err))
+		return
+	}
+	retriesMetric.Inc()
+	slog.InfoContext(ctx, "Retrying receipt", logging.Receipt(msg.Id), "retry_in", tier.Delay, logging.Err(err))
+	m.NakWithDelay(tier.Delay)
+}
+
+// park copies a receipt to the dead letter subject along with the reason it failed, then tells JetStream to stop redelivering it
+func (s *NATSSubscriber) park(ctx context.Context, m jetstream.Msg, msg Message, reason string) {
+	deadLettersMetric.Inc()
+	// Send an alert to the admin/monitoring system as well
+	alerting.Raise(ctx, alerting.DeadLettered, "Parking receipt on the dead letter subject", logging.Receipt(msg.Id), "subject", natsDeadLetterSubject, "reason", reason)
+	msg.Headers[FailureReasonHeader] = reason
+	// The copy goes to the same stream, which would take it for a duplicate of the original under the original's ID and drop it
+	delete(msg.Headers, nats.MsgIdHdr)
+	publishCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := s.js.PublishMsg(publishCtx, natsMessage(natsDeadLetterSubject, msg), jetstream.WithMsgID(msg.Id+".failed")); err != nil {
+		alerting.Raise(ctx, alerting.DeadLettered, "Error while saving receipt to the dead letter subject", logging.Receipt(msg.Id), logging.Err(err))
+		m.NakWithDelay(RetryTiers[0].Delay) // Keep it in the stream rather than lose it
+		return
+	}
+	m.Term()
+}
@@ -0,0 +1,104 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// runJetStream starts a NATS server with JetStream in the test process and returns its URL
+func runJetStream(t *testing.T) string {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(ns.Shutdown)
+	return ns.ClientURL()
+}
+
+// A receipt parked right after it was published must still reach the dead letter subject, even though the stream is still
+// deduplicating on its Id
+func TestNATSParksPoisonReceipt(t *testing.T) {
+	url := runJetStream(t)
+	publisher, err := NewNATSPublisher(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	subscriber, err := NewNATSSubscriber(url, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+
+	msg := Message{Id: "a", Body: []byte(`{"Id":"a"}`), Headers: map[string]string{RequestIDHeader: "req-1"}}
+	if err := publisher.Publish(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := make(chan struct{}, 1)
+	subscribed := make(chan error, 1)
+	go func() {
+		subscribed <- subscriber.Subscribe(ctx, func(ctx context.Context, msg Message) error {
+			handled <- struct{}{}
+			return fmt.Errorf("receipt is broken: %w", ErrPoison)
+		}, func() {})
+	}()
+	defer func() {
+		cancel()
+		if err := <-subscribed; err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-handled:
+	case err := <-subscribed:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the receipt was not delivered")
+	}
+
+	stream, err := publisher.js.Stream(context.Background(), natsStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parked *jetstream.RawStreamMsg
+	eventually(t, "the receipt is on the dead letter subject", func() bool {
+		parked, err = stream.GetLastMsgForSubject(context.Background(), natsDeadLetterSubject)
+		return err == nil
+	})
+	if string(parked.Data) != string(msg.Body) {
+		t.Errorf("parked %s, want %s", parked.Data, msg.Body)
+	}
+	if reason := parked.Header.Get(FailureReasonHeader); reason == "" {
+		t.Errorf("parked without a %s header", FailureReasonHeader)
+	}
+	if reqId := parked.Header.Get(RequestIDHeader); reqId != "req-1" {
+		t.Errorf("parked with request ID %q, want req-1", reqId)
+	}
+
+	// Terminated, so it is neither redelivered nor waiting for an ack
+	consumer, err := publisher.js.Consumer(context.Background(), natsStream, natsConsumer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the original is settled", func() bool {
+		info, err := consumer.Info(context.Background())
+		return err == nil && info.NumAckPending == 0 && info.NumPending == 0
+	})
+	select {
+	case <-handled:
+		t.Error("the parked receipt was delivered again")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
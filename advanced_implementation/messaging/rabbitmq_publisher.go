@@ -0,0 +1,270 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"restGo/logging"
+	"restGo/tracing"
+)
+
+// The parts of *amqp.Channel that the publisher uses, so an in-process stand-in can replace the broker
+type confirmChannel interface {
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(returns chan amqp.Return) chan amqp.Return
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+var (
+	errNacked       = errors.New("the broker refused the receipt")
+	errUnroutable   = errors.New("the receipt could not be routed to a queue")
+	errNotConfirmed = errors.New("the broker did not confirm the receipt in time")
+	errChannelGone  = errors.New("the channel was closed before the receipt was confirmed")
+)
+
+type pendingPublish struct {
+	Id   string
+	done chan error
+}
+
+// confirmPublisher puts the channel into confirm mode and publishes with mandatory set, so a receipt only counts as delivered once the
+// broker has acked it and it was not returned as unroutable. RabbitMQ numbers publishes on a channel 1, 2, 3... which is how a
+// confirmation is matched back to the receipt that is waiting for it
+type confirmPublisher struct {
+	queueName string
+	timeout   time.Duration
+
+	mu         sync.Mutex
+	ch         confirmChannel
+	generation int // Bumped on every attach so a listener for an old channel can't touch the new one
+	nextTag    uint64
+	pending    map[uint64]*pendingPublish
+	returned   map[string]bool // Ids of receipts that came back through NotifyReturn
+	closed     bool
+}
+
+// newConfirmPublisher returns a publisher that fails every deliver until a channel is attached
+func newConfirmPublisher(queueName string, timeout time.Duration) *confirmPublisher {
+	return &confirmPublisher{
+		queueName: queueName,
+		timeout:   timeout,
+		pending:   map[uint64]*pendingPublish{},
+		returned:  map[string]bool{},
+		closed:    true,
+	}
+}
+
+// attach starts publishing on a new channel, e.g. after a reconnect. Receipts still waiting on the old channel are failed and
+// end up in the outbox
+func (p *confirmPublisher) attach(ch confirmChannel) error {
+	if err := ch.Confirm(false); err != nil {
+		return err
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 100))
+	returns := ch.NotifyReturn(make(chan amqp.Return, 100))
+
+	p.mu.Lock()
+	p.failPending()
+	p.ch = ch
+	p.generation++
+	p.nextTag = 0 // Delivery tags start again from 1 on every channel
+	p.returned = map[string]bool{}
+	p.closed = false
+	generation := p.generation
+	p.mu.Unlock()
+
+	go p.listen(generation, confirms, returns)
+	return nil
+}
+
+func (p *confirmPublisher) listen(generation int, confirms chan amqp.Confirmation, returns chan amqp.Return) {
+	for {
+		select {
+		case r, ok := <-returns:
+			if !ok {
+				returns = nil // Wait for the confirms channel to close as well
+				continue
+			}
+			p.markReturned(r)
+		case c, ok := <-confirms:
+			if !ok {
+				p.channelClosed(generation)
+				return
+			}
+			// A basic.return is always sent before the basic.ack of the same message, so pick up any that are still buffered
+			p.drainReturns(returns)
+			p.resolve(generation, c)
+		}
+	}
+}
+
+func (p *confirmPublisher) markReturned(r amqp.Return) {
+	slog.Error("Receipt was returned by the broker", logging.Receipt(r.MessageId), "reason", r.ReplyText)
+	p.mu.Lock()
+	p.returned[r.MessageId] = true
+	p.mu.Unlock()
+}
+
+func (p *confirmPublisher) drainReturns(returns chan amqp.Return) {
+	for {
+		select {
+		case r, ok := <-returns:
+			if !ok {
+				return
+			}
+			p.markReturned(r)
+		default:
+			return
+		}
+	}
+}
+
+func (p *confirmPublisher) resolve(generation int, c amqp.Confirmation) {
+	p.mu.Lock()
+	if generation != p.generation {
+		p.mu.Unlock()
+		return // Tags from an old channel mean nothing on the new one
+	}
+	waiting, ok := p.pending[c.DeliveryTag]
+	delete(p.pending, c.DeliveryTag)
+	var wasReturned bool
+	if ok {
+		wasReturned = p.returned[waiting.Id]
+		delete(p.returned, waiting.Id)
+	}
+	p.mu.Unlock()
+	if !ok {
+		return // The publisher already gave up waiting for this one
+	}
+	switch {
+	case !c.Ack:
+		waiting.done <- errNacked
+	case wasReturned:
+		waiting.done <- errUnroutable
+	default:
+		waiting.done <- nil
+	}
+}
+
+func (p *confirmPublisher) channelClosed(generation int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if generation != p.generation {
+		return // A newer channel is already attached
+	}
+	p.closed = true
+	p.failPending()
+}
+
+// failPending must be called with the lock held
+func (p *confirmPublisher) failPending() {
+	for tag, waiting := range p.pending {
+		waiting.done <- errChannelGone
+		delete(p.pending, tag)
+	}
+}
+
+// deliver publishes a receipt and blocks until the broker confirms it, the publisher's timeout passes or ctx is done, whichever
+// comes first. A nil error means RabbitMQ has taken responsibility for it
+func (p *confirmPublisher) deliver(ctx context.Context, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	// The client doesn't check the deadline itself, so don't start a publish nobody is waiting for
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	waiting := &pendingPublish{Id: msg.Id, done: make(chan error, 1)}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errChannelGone
+	}
+	// Publishing and numbering happen under the same lock so the tags line up with the order RabbitMQ sees
+	err := p.ch.PublishWithContext(
+		ctx,
+		"",
+		p.queueName,
+		true, // mandatory, so an unroutable receipt is returned instead of silently dropped
+		false,
+		amqp.Publishing{
+			Headers:      toTable(msg.Headers),
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent, // Survive a RabbitMQ restart, the queue is durable as well
+			MessageId:    msg.Id,
+			Body:         msg.Body,
+		},
+	)
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	p.nextTag++
+	tag := p.nextTag
+	p.pending[tag] = waiting
+	p.mu.Unlock()
+
+	select {
+	case err := <-waiting.done:
+		return err
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.pending, tag)
+		p.mu.Unlock()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return errNotConfirmed
+		}
+		return ctx.Err()
+	}
+}
+
+func toTable(headers map[string]string) amqp.Table {
+	table := amqp.Table{}
+	for k, v := range headers {
+		table[k] = v
+	}
+	return table
+}
+
+// RabbitMQPublisher publishes receipts to ReceiptsQueue with publisher confirms. Every connection gets a fresh publishing channel,
+// and receipts that were waiting on the old one fail so the caller can keep them for later
+type RabbitMQPublisher struct {
+	conn     *Connection
+	confirms *confirmPublisher
+}
+
+func NewRabbitMQPublisher(conn *Connection) *RabbitMQPublisher {
+	p := &RabbitMQPublisher{
+		conn:     conn,
+		confirms: newConfirmPublisher(ReceiptsQueue, 5*time.Second),
+	}
+	err := conn.KeepChannel(func(ch *amqp.Channel) error {
+		return p.confirms.attach(ch)
+	})
+	if err != nil {
+		// Publishing fails until the next reconnect opens a channel that works
+		slog.Error("Failed to open a publishing channel in confirm mode", logging.Err(err))
+	}
+	return p
+}
+
+// Connection is the RabbitMQ connection the publisher uses, for the dead letter tools and queue checks
+func (p *RabbitMQPublisher) Connection() *Connection {
+	return p.conn
+}
+
+func (p *RabbitMQPublisher) Publish(ctx context.Context, msg Message) error {
+	ctx, msg, span := startPublish(ctx, RabbitMQ, ReceiptsQueue, msg)
+	err := p.confirms.deliver(ctx, msg)
+	tracing.End(span, err)
+	return err
+}
+
+func (p *RabbitMQPublisher) Close() error {
+	return p.conn.Close()
+}
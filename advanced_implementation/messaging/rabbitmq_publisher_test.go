@@ -0,0 +1,158 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeChannel stands in for a RabbitMQ channel in confirm mode. The test plays the broker: it reads what was published and
+// answers with confirmations and returns
+type fakeChannel struct {
+	confirms  chan amqp.Confirmation
+	returns   chan amqp.Return
+	published chan amqp.Publishing
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{published: make(chan amqp.Publishing, 10)}
+}
+
+func (c *fakeChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (c *fakeChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.confirms = confirm
+	return confirm
+}
+
+func (c *fakeChannel) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	c.returns = returns
+	return returns
+}
+
+func (c *fakeChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if !mandatory {
+		return errors.New("receipts must be published as mandatory")
+	}
+	c.published <- msg
+	return nil
+}
+
+// close does what amqp091 does when the channel goes away
+func (c *fakeChannel) close() {
+	close(c.returns)
+	close(c.confirms)
+}
+
+// publishing starts a deliver and waits for the message to reach the broker
+func publishing(t *testing.T, p *confirmPublisher, ch *fakeChannel, Id string) chan error {
+	t.Helper()
+	result := make(chan error, 1)
+	go func() {
+		result <- p.deliver(context.Background(), Message{Id: Id, Body: []byte("{}")})
+	}()
+	select {
+	case msg := <-ch.published:
+		if msg.MessageId != Id {
+			t.Fatalf("published %s, want %s", msg.MessageId, Id)
+		}
+	case err := <-result:
+		t.Fatalf("deliver returned %v before publishing", err)
+	case <-time.After(time.Second):
+		t.Fatal("nothing was published")
+	}
+	return result
+}
+
+func awaitResult(t *testing.T, result chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("deliver did not return")
+		return nil
+	}
+}
+
+func attached(t *testing.T, timeout time.Duration) (*confirmPublisher, *fakeChannel) {
+	t.Helper()
+	p := newConfirmPublisher("receipts", timeout)
+	ch := newFakeChannel()
+	if err := p.attach(ch); err != nil {
+		t.Fatal(err)
+	}
+	return p, ch
+}
+
+func TestConfirmPublisher(t *testing.T) {
+	tests := []struct {
+		name   string
+		broker func(ch *fakeChannel)
+		want   error
+	}{
+		{"ack", func(ch *fakeChannel) {
+			ch.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+		}, nil},
+		{"nack", func(ch *fakeChannel) {
+			ch.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+		}, errNacked},
+		{"return then ack", func(ch *fakeChannel) {
+			ch.returns <- amqp.Return{MessageId: "a", ReplyText: "NO_ROUTE"}
+			ch.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+		}, errUnroutable},
+		{"channel closed", func(ch *fakeChannel) {
+			ch.close()
+		}, errChannelGone},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, ch := attached(t, time.Second)
+			delivered := publishing(t, p, ch, "a")
+			test.broker(ch)
+			if err := awaitResult(t, delivered); !errors.Is(err, test.want) {
+				t.Errorf("deliver() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestConfirmPublisherTimeout(t *testing.T) {
+	p, ch := attached(t, 100*time.Millisecond)
+	if err := awaitResult(t, publishing(t, p, ch, "a")); !errors.Is(err, errNotConfirmed) {
+		t.Fatalf("deliver() = %v, want %v", err, errNotConfirmed)
+	}
+
+	// The confirmation that comes in after the publisher gave up must not be taken for the next receipt's
+	delivered := publishing(t, p, ch, "b")
+	ch.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+	ch.confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+	if err := awaitResult(t, delivered); err != nil {
+		t.Errorf("deliver() = %v after a late confirmation, want nil", err)
+	}
+}
+
+func TestConfirmPublisherReattach(t *testing.T) {
+	p, ch := attached(t, time.Second)
+	ch.close()
+	// Whether or not the listener has noticed the close yet, the receipt is failed rather than left waiting
+	if err := p.deliver(context.Background(), Message{Id: "a"}); !errors.Is(err, errChannelGone) {
+		t.Fatalf("deliver() = %v on a closed channel, want %v", err, errChannelGone)
+	}
+
+	// A new channel numbers its publishes from 1 again
+	next := newFakeChannel()
+	if err := p.attach(next); err != nil {
+		t.Fatal(err)
+	}
+	delivered := publishing(t, p, next, "b")
+	next.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	if err := awaitResult(t, delivered); err != nil {
+		t.Errorf("deliver() = %v on the new channel, want nil", err)
+	}
+}
@@ -0,0 +1,203 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"restGo/alerting"
+	"restGo/logging"
+)
+
+// republisher publishes on a channel in confirm mode, so a delivery is only acked once RabbitMQ has its copy
+type republisher struct {
+	ch *amqp.Channel
+}
+
+func newRepublisher(ch *amqp.Channel) (*republisher, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+	return &republisher{ch: ch}, nil
+}
+
+// republish sends a copy of the delivery with the given headers and waits for RabbitMQ to confirm it
+func (p *republisher) republish(ctx context.Context, exchange, key string, d amqp.Delivery, headers amqp.Table) error {
+	return publishConfirmed(ctx, p.ch, exchange, key, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    d.MessageId,
+		Body:         d.Body,
+	})
+}
+
+// publishConfirmed publishes on a channel in confirm mode and waits up to 5 seconds for RabbitMQ to confirm the message. The
+// library matches up the confirmations itself, so one that comes in after we gave up is dropped instead of blocking the
+// connection, and workers sharing the channel can wait at the same time
+func publishConfirmed(ctx context.Context, ch *amqp.Channel, exchange, key string, msg amqp.Publishing) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, msg)
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return errors.New("broker did not confirm the message in time")
+	case err != nil:
+		return err
+	case !acked:
+		return errors.New("broker refused the message or the channel closed before it was confirmed")
+	}
+	return nil
+}
+
+// RabbitMQSubscriber consumes ReceiptsQueue. Transient failures go through the retry queues and poison messages are parked in
+// DeadLetterQueue along with the reason
+type RabbitMQSubscriber struct {
+	conn     *Connection
+	prefetch int
+	workers  int
+}
+
+func NewRabbitMQSubscriber(conn *Connection, prefetch, workers int) *RabbitMQSubscriber {
+	return &RabbitMQSubscriber{conn: conn, prefetch: prefetch, workers: workers}
+}
+
+// Every subscription gets the same tag, since there is only ever one on a channel
+const consumerTag = "receipts"
+
+// Subscribe consumes on a channel of its own. On every reconnect the channel and the subscription are set up again.
+// KeepChannel only returns once the first Consume has succeeded, which is when ready is called
+func (s *RabbitMQSubscriber) Subscribe(ctx context.Context, handler Handler, ready func()) error {
+	pool := newWorkerPool(s.workers)
+	err := s.conn.KeepChannel(func(ch *amqp.Channel) error {
+		return s.consume(ctx, ch, pool, handler)
+	})
+	if err != nil {
+		pool.stop()
+		return err
+	}
+	ready()
+	<-ctx.Done()
+	pool.stop() // Deliveries prefetched but not handed to a worker go back to the queue when the channel closes
+	return nil
+}
+
+func (s *RabbitMQSubscriber) Close() error {
+	return s.conn.Close()
+}
+
+// consume starts consuming on a channel. The delivery loop ends when the channel closes, and the channel that replaces it starts
+// a new one
+func (s *RabbitMQSubscriber) consume(ctx context.Context, ch *amqp.Channel, pool *workerPool, handler Handler) error {
+	// Without a limit RabbitMQ pushes the whole queue at once. With one, a slow consumer leaves the rest to the others
+	if err := ch.Qos(s.prefetch, 0, false); err != nil {
+		return err
+	}
+	// Retries and dead letters are published on the same channel, acked deliveries and publisher confirms are numbered separately
+	publisher, err := newRepublisher(ch)
+	if err != nil {
+		return err
+	}
+	msgs, err := ch.Consume(
+		ReceiptsQueue,
+		consumerTag,
+		false, // Messages are acked by hand once the handler is done with them
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	work := context.WithoutCancel(ctx)
+	go func() {
+		for {
+			select {
+			case d, ok := <-msgs:
+				if !ok {
+					slog.Warn("Stopped consuming until RabbitMQ is back")
+					return
+				}
+				pool.dispatch(d.MessageId, func() {
+					handleDelivery(work, publisher, d, handler)
+				})
+			case <-ctx.Done():
+				ch.Cancel(consumerTag, false) // No new deliveries, the workers finish the ones they have
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func fromTable(table amqp.Table) map[string]string {
+	headers := map[string]string{}
+	for k, v := range table {
+		if value, ok := v.(string); ok {
+			headers[k] = value
+		}
+	}
+	return headers
+}
+
+// handleDelivery runs the handler on one delivery and settles it exactly once: ack, retry or park
+func handleDelivery(ctx context.Context, publisher *republisher, d amqp.Delivery, handler Handler) {
+	msg := Message{Id: d.MessageId, Body: d.Body, Headers: fromTable(d.Headers)}
+	ctx, span := startProcess(ctx, RabbitMQ, ReceiptsQueue, msg)
+	defer span.End()
+	err := handler(ctx, msg)
+	switch {
+	case err == nil:
+		// Only now is it safe for RabbitMQ to forget the message
+		if err := d.Ack(false); err != nil {
+			// The handler is done with it, so the redelivery that follows a lost ack has to be harmless
+			slog.WarnContext(ctx, "Failed to ack receipt, it will be redelivered", logging.Receipt(d.MessageId), logging.Err(err))
+		}
+	case errors.Is(err, ErrPoison):
+		park(ctx, publisher, d, err.Error())
+	default:
+		retry(ctx, publisher, d, err)
+	}
+}
+
+// retry sends a receipt to the next retry queue, or parks it once all of them have been used
+func retry(ctx context.Context, publisher *republisher, d amqp.Delivery, cause error) {
+	retryCount := RetryCount(d.Headers)
+	tier, ok := NextRetryTier(retryCount)
+	if !ok {
+		park(ctx, publisher, d, fmt.Sprintf("gave up after %d retries: %v", retryCount, cause))
+		return
+	}
+	headers := WithHeader(d.Headers, RetryCountHeader, int32(retryCount+1))
+	if err := publisher.republish(ctx, RetryExchange, tier.Name, d, headers); err != nil {
+		slog.ErrorContext(ctx, "Failed to send receipt to the retry queue", logging.Receipt(d.MessageId), "queue", tier.Name, logging.Err(err))
+		d.Nack(false, true) // Keep it on the main queue rather than lose it
+		return
+	}
+	retriesMetric.Inc()
+	slog.InfoContext(ctx, "Retrying receipt", logging.Receipt(d.MessageId), "retry_in", tier.Delay, logging.Err(cause))
+	d.Ack(false)
+}
+
+// park moves a receipt to the dead letter queue along with the reason it failed
+func park(ctx context.Context, publisher *republisher, d amqp.Delivery, reason string) {
+	deadLettersMetric.Inc()
+	// Send an alert to the admin/monitoring system as well
+	alerting.Raise(ctx, alerting.DeadLettered, "Parking receipt in the dead letter queue", logging.Receipt(d.MessageId), "reason", reason)
+	headers := WithHeader(d.Headers, FailureReasonHeader, reason)
+	if err := publisher.republish(ctx, DeadLetterExchange, "", d, headers); err != nil {
+		alerting.Raise(ctx, alerting.DeadLettered, "Error while saving receipt to the dead letter queue", logging.Receipt(d.MessageId), logging.Err(err))
+		// Rejecting still dead-letters it through the queue's own dead letter exchange, just without the reason
+		d.Nack(false, false)
+		return
+	}
+	d.Ack(false)
+}
@@ -0,0 +1,258 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// subscribe consumes ReceiptsQueue from the broker until the test ends
+func subscribe(t *testing.T, broker *fakeBroker, handler Handler) {
+	t.Helper()
+	subscriber := NewRabbitMQSubscriber(Connect(broker.URL()), 10, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- subscriber.Subscribe(ctx, handler, func() { close(ready) })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		subscriber.Close()
+	})
+	select {
+	case <-ready:
+	case err := <-done:
+		t.Fatalf("Subscribe() = %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe() did not start consuming")
+	}
+}
+
+func publishReceipt(broker *fakeBroker, Id string, headers amqp.Table) {
+	broker.publish("", ReceiptsQueue, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    Id,
+		Headers:      headers,
+		Body:         []byte(`{"Id":"` + Id + `"}`),
+	})
+}
+
+func TestSubscriberAcksOnlyOnceHandled(t *testing.T) {
+	broker := newFakeBroker(t)
+	handling := make(chan Message)
+	stored := make(chan struct{})
+	subscribe(t, broker, func(ctx context.Context, msg Message) error {
+		handling <- msg
+		<-stored
+		return nil
+	})
+
+	publishReceipt(broker, "a", amqp.Table{RequestIDHeader: "req-1"})
+	msg := <-handling
+	if msg.Id != "a" || string(msg.Body) != `{"Id":"a"}` || msg.Headers[RequestIDHeader] != "req-1" {
+		t.Errorf("handled %+v, want receipt a with its request Id", msg)
+	}
+	// Until the handler has stored the receipt, RabbitMQ still holds it for us
+	time.Sleep(20 * time.Millisecond)
+	if broker.unacked(ReceiptsQueue) != 1 {
+		t.Fatalf("%d unacked while the handler runs, want 1", broker.unacked(ReceiptsQueue))
+	}
+	close(stored)
+	eventually(t, "the receipt is acked", func() bool {
+		return broker.unacked(ReceiptsQueue) == 0
+	})
+	if Ids := broker.messageIds(ReceiptsQueue); len(Ids) != 0 {
+		t.Errorf("%v still in the queue after it was handled", Ids)
+	}
+}
+
+func TestSubscriberGetsReceiptAgainWhenConnectionDropsBeforeAck(t *testing.T) {
+	broker := newFakeBroker(t)
+	var calls atomic.Int32
+	handling := make(chan struct{})
+	release := make(chan struct{})
+	subscribe(t, broker, func(ctx context.Context, msg Message) error {
+		if calls.Add(1) == 1 {
+			close(handling)
+			<-release // The connection drops before the receipt is stored
+		}
+		return nil
+	})
+
+	publishReceipt(broker, "a", nil)
+	<-handling
+	broker.dropConnections()
+	eventually(t, "the receipt is back in the queue", func() bool {
+		return len(broker.messageIds(ReceiptsQueue)) == 1 || calls.Load() == 2
+	})
+	close(release)
+	// The consumer reconnects and gets it again, so a receipt is never lost between the broker and the database
+	eventually(t, "the receipt is handled again and acked", func() bool {
+		return calls.Load() == 2 && broker.unacked(ReceiptsQueue) == 0 && len(broker.messageIds(ReceiptsQueue)) == 0
+	})
+}
+
+var errDatabaseDown = errors.New("database is down")
+
+// failing is a handler that always fails with err
+func failing(err error) Handler {
+	return func(ctx context.Context, msg Message) error {
+		return err
+	}
+}
+
+func TestSubscriberRetriesThroughTheNextTier(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryCount interface{} // x-retry-count as it arrives, nil when there is none
+		wantQueue  string
+		wantCount  int32
+	}{
+		{"first failure", nil, RetryQueueName(RetryTiers[0]), 1},
+		{"after one retry", int32(1), RetryQueueName(RetryTiers[1]), 2},
+		{"count as int64", int64(2), RetryQueueName(RetryTiers[2]), 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker := newFakeBroker(t)
+			subscribe(t, broker, failing(errDatabaseDown))
+			headers := amqp.Table{RequestIDHeader: "req-1"}
+			if test.retryCount != nil {
+				headers[RetryCountHeader] = test.retryCount
+			}
+			publishReceipt(broker, "a", headers)
+
+			eventually(t, "the receipt is in "+test.wantQueue, func() bool {
+				return len(broker.messageIds(test.wantQueue)) == 1 && broker.unacked(ReceiptsQueue) == 0
+			})
+			q, _ := broker.queue(test.wantQueue)
+			msg := q.messages[0]
+			if msg.props.Headers[RetryCountHeader] != test.wantCount || msg.props.Headers[RequestIDHeader] != "req-1" {
+				t.Errorf("retried with headers %v, want %s %d and the request Id", msg.props.Headers, RetryCountHeader, test.wantCount)
+			}
+			if msg.props.MessageId != "a" || msg.props.DeliveryMode != amqp.Persistent {
+				t.Errorf("retried %s with delivery mode %d, want a as persistent", msg.props.MessageId, msg.props.DeliveryMode)
+			}
+			if Ids := broker.messageIds(ReceiptsQueue); len(Ids) != 0 {
+				t.Errorf("%v still in %s", Ids, ReceiptsQueue)
+			}
+		})
+	}
+}
+
+func TestSubscriberGetsRetriedReceiptBack(t *testing.T) {
+	tiers := RetryTiers
+	RetryTiers = []RetryTier{{Name: "fast", Delay: 20 * time.Millisecond}}
+	t.Cleanup(func() { RetryTiers = tiers })
+
+	broker := newFakeBroker(t)
+	var calls atomic.Int32
+	subscribe(t, broker, func(ctx context.Context, msg Message) error {
+		if calls.Add(1) == 1 {
+			return errDatabaseDown
+		}
+		return nil
+	})
+	publishReceipt(broker, "a", nil)
+
+	// The TTL runs out and the retry queue dead-letters the receipt back to the main queue
+	eventually(t, "the receipt is handled again and acked", func() bool {
+		return calls.Load() == 2 && broker.unacked(ReceiptsQueue) == 0
+	})
+	for _, queue := range []string{ReceiptsQueue, RetryQueueName(RetryTiers[0]), DeadLetterQueue} {
+		if Ids := broker.messageIds(queue); len(Ids) != 0 {
+			t.Errorf("%v left in %s", Ids, queue)
+		}
+	}
+}
+
+func TestSubscriberParks(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		retryCount int32
+		wantReason string
+	}{
+		{"poison", fmt.Errorf("%w: not JSON", ErrPoison), 0, "poison message: not JSON"},
+		{"out of retries", errDatabaseDown, int32(len(RetryTiers)), fmt.Sprintf("gave up after %d retries: database is down", len(RetryTiers))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker := newFakeBroker(t)
+			subscribe(t, broker, failing(test.err))
+			broker.holdBackConfirms()
+			publishReceipt(broker, "a", amqp.Table{RetryCountHeader: test.retryCount})
+
+			eventually(t, "the receipt is parked", func() bool {
+				return len(broker.messageIds(DeadLetterQueue)) == 1
+			})
+			// RabbitMQ hasn't confirmed the parked copy, so the original can't be let go yet
+			time.Sleep(20 * time.Millisecond)
+			if broker.unacked(ReceiptsQueue) != 1 {
+				t.Fatalf("%d unacked before the confirm, want 1", broker.unacked(ReceiptsQueue))
+			}
+			broker.releaseConfirms()
+			eventually(t, "the original is acked", func() bool {
+				return broker.unacked(ReceiptsQueue) == 0
+			})
+
+			q, _ := broker.queue(DeadLetterQueue)
+			if reason := q.messages[0].props.Headers[FailureReasonHeader]; reason != test.wantReason {
+				t.Errorf("parked with reason %q, want %q", reason, test.wantReason)
+			}
+			if Ids := broker.messageIds(ReceiptsQueue); len(Ids) != 0 {
+				t.Errorf("%v still in %s", Ids, ReceiptsQueue)
+			}
+		})
+	}
+}
+
+func TestSubscriberRejectsWhenParkingFails(t *testing.T) {
+	broker := newFakeBroker(t)
+	subscribe(t, broker, failing(ErrPoison))
+	broker.refusePublishes(true)
+	publishReceipt(broker, "a", nil)
+
+	// The queue's own dead letter exchange still parks it, with the broker's x-death instead of the reason
+	eventually(t, "the receipt is parked", func() bool {
+		return len(broker.messageIds(DeadLetterQueue)) == 1 && broker.unacked(ReceiptsQueue) == 0
+	})
+	q, _ := broker.queue(DeadLetterQueue)
+	if _, ok := q.messages[0].props.Headers[FailureReasonHeader]; ok {
+		t.Error("parked with a reason although the copy was refused")
+	}
+	if reason := failureReason(q.messages[0].props.Headers); reason != "rejected by the broker from "+ReceiptsQueue {
+		t.Errorf("failureReason() = %q, want it rejected from %s", reason, ReceiptsQueue)
+	}
+}
+
+func TestSubscriberKeepsReceiptWhenRetryFails(t *testing.T) {
+	broker := newFakeBroker(t)
+	var calls atomic.Int32
+	subscribe(t, broker, func(ctx context.Context, msg Message) error {
+		calls.Add(1)
+		return errDatabaseDown
+	})
+	broker.refusePublishes(true)
+	publishReceipt(broker, "a", nil)
+
+	// It goes back to the main queue rather than being lost, and is tried again
+	eventually(t, "the receipt is handled again", func() bool {
+		return calls.Load() >= 2
+	})
+	if Ids := broker.messageIds(RetryQueueName(RetryTiers[0])); len(Ids) != 0 {
+		t.Errorf("%v in the retry queue although the broker refused it", Ids)
+	}
+	broker.refusePublishes(false)
+	eventually(t, "the receipt is in the retry queue", func() bool {
+		return len(broker.messageIds(RetryQueueName(RetryTiers[0]))) == 1 && broker.unacked(ReceiptsQueue) == 0
+	})
+}
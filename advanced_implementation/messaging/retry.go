@@ -0,0 +1,42 @@
+package messaging
+
+import amqp "github.com/rabbitmq/amqp091-go"
+
+const (
+	RetryCountHeader    = "x-retry-count"    // How many times the consumer has sent a receipt to a retry queue
+	FailureReasonHeader = "x-failure-reason" // Why the consumer gave up on a receipt, set when it is parked
+	PublishedAtHeader   = "x-published-at"   // When the server published a receipt, in RFC 3339. Tells the consumer how far behind it is
+	RequestIDHeader     = "x-request-id"     // The HTTP request that submitted a receipt, so its log lines can be followed to the consumer
+)
+
+// RetryCount reads the retry count of a delivery. Receipts coming straight from the server have none
+func RetryCount(headers amqp.Table) int {
+	switch count := headers[RetryCountHeader].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	}
+	return 0
+}
+
+// NextRetryTier returns the retry queue for a receipt that has already been retried retryCount times. It returns false once
+// every tier has been used, meaning the receipt should be parked
+func NextRetryTier(retryCount int) (RetryTier, bool) {
+	if retryCount < 0 || retryCount >= len(RetryTiers) {
+		return RetryTier{}, false
+	}
+	return RetryTiers[retryCount], true
+}
+
+// WithHeader returns a copy of the headers with one value changed, leaving the delivery's own table untouched
+func WithHeader(headers amqp.Table, key string, value interface{}) amqp.Table {
+	copied := amqp.Table{}
+	for k, v := range headers {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
@@ -0,0 +1,280 @@
+// Package messaging holds the message bus shared by the server and the consumer, and the RabbitMQ setup they both agree on
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// The queue names can be changed through BusConfig
+var (
+	ReceiptsQueue   = "POST_receipts"   // Validated receipts published by the server
+	DeadLetterQueue = "failed_receipts" // Parking queue for receipts that could not be stored, even after retrying
+)
+
+const (
+	DeadLetterExchange = "receipts.dlx"   // Everything dead-lettered ends up in DeadLetterQueue through this exchange
+	RetryExchange      = "receipts.retry" // Routes a receipt to one of the retry queues by the tier name
+)
+
+// RetryTier is a queue where a receipt waits before RabbitMQ dead-letters it back to ReceiptsQueue
+type RetryTier struct {
+	Name  string
+	Delay time.Duration
+}
+
+// A receipt that keeps failing with a transient error is retried after each delay in turn before it is parked
+var RetryTiers = []RetryTier{
+	{Name: "5s", Delay: 5 * time.Second},
+	{Name: "1m", Delay: time.Minute},
+	{Name: "10m", Delay: 10 * time.Minute},
+}
+
+func RetryQueueName(tier RetryTier) string {
+	return ReceiptsQueue + ".retry." + tier.Name
+}
+
+type exchangeSpec struct {
+	name string
+	kind string
+}
+
+var exchanges = []exchangeSpec{
+	{name: DeadLetterExchange, kind: amqp.ExchangeFanout},
+	{name: RetryExchange, kind: amqp.ExchangeDirect},
+}
+
+type queueSpec struct {
+	name       string
+	args       amqp.Table
+	exchange   string // Exchange the queue is bound to, if any
+	bindingKey string
+}
+
+// Every queue is durable and every message is published as persistent, so a RabbitMQ restart keeps them
+func buildTopology() []queueSpec {
+	queues := []queueSpec{
+		{
+			name: ReceiptsQueue,
+			// A rejected receipt is moved to the parking queue by RabbitMQ itself
+			args: amqp.Table{"x-dead-letter-exchange": DeadLetterExchange},
+		},
+		{name: DeadLetterQueue, exchange: DeadLetterExchange},
+	}
+	for _, tier := range RetryTiers {
+		// Nothing consumes a retry queue. Once the TTL runs out RabbitMQ dead-letters the receipt back to ReceiptsQueue
+		queues = append(queues, queueSpec{
+			name: RetryQueueName(tier),
+			args: amqp.Table{
+				"x-message-ttl":             int64(tier.Delay / time.Millisecond),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": ReceiptsQueue,
+			},
+			exchange:   RetryExchange,
+			bindingKey: tier.Name,
+		})
+	}
+	return queues
+}
+
+// DeclareTopology declares all exchanges, queues and bindings. A queue left over from an older version with different settings
+// (e.g. non-durable) makes RabbitMQ refuse the declaration, in which case its messages are moved to a queue with the new settings
+func DeclareTopology(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	for _, spec := range exchanges {
+		if err := ch.ExchangeDeclare(spec.name, spec.kind, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("declaring exchange %s: %w", spec.name, err)
+		}
+	}
+	for _, spec := range buildTopology() {
+		err := declareQueue(conn, spec.name, spec.args)
+		if isPreconditionFailed(err) {
+			slog.Warn("Queue was declared with different settings. Migrating it", "queue", spec.name)
+			err = migrateQueue(conn, spec)
+		}
+		if err != nil {
+			return fmt.Errorf("declaring queue %s: %w", spec.name, err)
+		}
+		if err := recoverMigration(conn, spec); err != nil {
+			return fmt.Errorf("recovering migration of queue %s: %w", spec.name, err)
+		}
+		if spec.exchange != "" {
+			if err := ch.QueueBind(spec.name, spec.bindingKey, spec.exchange, false, nil); err != nil {
+				return fmt.Errorf("binding queue %s: %w", spec.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// A failed declaration closes the channel, so each one gets a channel of its own
+func declareQueue(conn *amqp.Connection, name string, args amqp.Table) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	_, err = ch.QueueDeclare(
+		name,
+		true, // durable
+		false,
+		false,
+		false,
+		args,
+	)
+	return err
+}
+
+func migrationQueueName(name string) string {
+	return name + ".migrating"
+}
+
+// How many times migrateQueue empties the old queue again when messages keep arriving in it
+const migrationAttempts = 5
+
+// migrateQueue works in three steps:
+//  1. Move every message from the old queue to a durable holding queue
+//  2. Delete the old queue if it is still empty and declare it again with the new settings
+//  3. Move the messages back and delete the holding queue (done by recoverMigration)
+//
+// The server and the consumer can both start a migration at the same time. The queue is only deleted while empty, so one of
+// them never deletes the queue the other has already declared again and filled. If the delete is refused the queue is
+// declared with the new settings: that works if another process has migrated it, and otherwise messages were published to
+// the old queue after step 1, so they are moved as well and the delete is tried again
+func migrateQueue(conn *amqp.Connection, spec queueSpec) error {
+	holding := migrationQueueName(spec.name)
+	if err := declareQueue(conn, holding, nil); err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		moved, err := moveMessages(conn, spec.name, holding)
+		if err != nil {
+			return err
+		}
+		slog.Info("Moved messages to the holding queue", "count", moved, "from", spec.name, "to", holding)
+
+		err = deleteIfEmpty(conn, spec.name)
+		if !isPreconditionFailed(err) {
+			if err != nil {
+				return err
+			}
+			return declareQueue(conn, spec.name, spec.args)
+		}
+		err = declareQueue(conn, spec.name, spec.args)
+		if err == nil {
+			slog.Info("Queue was already migrated by another process", "queue", spec.name)
+			return nil
+		}
+		if !isPreconditionFailed(err) {
+			return err
+		}
+		if attempt == migrationAttempts {
+			return fmt.Errorf("messages kept arriving in the old queue after %d attempts, stop the publishers and restart", attempt)
+		}
+	}
+}
+
+// A failed delete closes the channel, like a failed declaration
+func deleteIfEmpty(conn *amqp.Connection, name string) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	_, err = ch.QueueDelete(name, false, true, false)
+	return err
+}
+
+func isPreconditionFailed(err error) bool {
+	var amqpErr *amqp.Error
+	return errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed
+}
+
+// recoverMigration moves messages back from a holding queue, which is left behind if a migration is interrupted
+func recoverMigration(conn *amqp.Connection, spec queueSpec) error {
+	holding := migrationQueueName(spec.name)
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	_, err = ch.QueueDeclarePassive(holding, true, false, false, false, nil)
+	ch.Close()
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+		return nil // Nothing to recover
+	}
+	if err != nil {
+		return err
+	}
+	moved, err := moveMessages(conn, holding, spec.name)
+	if err != nil {
+		return err
+	}
+	slog.Info("Moved messages back from the holding queue", "count", moved, "from", holding, "to", spec.name)
+
+	ch, err = conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	_, err = ch.QueueDelete(holding, false, false, false)
+	return err
+}
+
+// moveMessages republishes every message from one queue to another as persistent. Each one is acked on the source only
+// after the broker has confirmed the copy
+func moveMessages(conn *amqp.Connection, from, to string) (int, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+	if err := ch.Confirm(false); err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for {
+		d, ok, err := ch.Get(from, false)
+		if err != nil {
+			return moved, err
+		}
+		if !ok {
+			return moved, nil // The source queue is empty
+		}
+		err = publishConfirmed(context.Background(), ch, "", to, amqp.Publishing{
+			Headers:      d.Headers,
+			ContentType:  d.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    d.MessageId,
+			Timestamp:    d.Timestamp,
+			Body:         d.Body,
+		})
+		if err != nil {
+			return moved, fmt.Errorf("moving a message to %s: %w", to, err)
+		}
+		if err := d.Ack(false); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+}
+
+// InspectQueue returns the state of a queue, such as how many messages are waiting in it
+func InspectQueue(conn *amqp.Connection, name string) (amqp.Queue, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	defer ch.Close()
+	return ch.QueueDeclarePassive(name, true, false, false, false, nil)
+}
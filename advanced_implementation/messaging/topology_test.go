@@ -0,0 +1,105 @@
+package messaging
+
+import (
+	"slices"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func receipts(Ids ...string) []amqp.Publishing {
+	var msgs []amqp.Publishing
+	for _, Id := range Ids {
+		msgs = append(msgs, amqp.Publishing{MessageId: Id, Body: []byte(`{"Id":"` + Id + `"}`)})
+	}
+	return msgs
+}
+
+func TestDeclareTopologyIsDurable(t *testing.T) {
+	broker := newFakeBroker(t)
+	dialTopology(t, broker)
+
+	for _, spec := range buildTopology() {
+		q, ok := broker.queue(spec.name)
+		if !ok || !q.durable {
+			t.Errorf("queue %s declared = %v, durable = %v, want a durable queue", spec.name, ok, q.durable)
+		}
+	}
+	// Declaring again with the same settings changes nothing
+	dialTopology(t, broker)
+}
+
+func TestDeclareTopologyMigratesNonDurableQueue(t *testing.T) {
+	broker := newFakeBroker(t)
+	// Left behind by a version that declared the queue non-durable and without a dead letter exchange
+	broker.addQueue(ReceiptsQueue, false, nil, receipts("a", "b", "c")...)
+	dialTopology(t, broker)
+
+	q, _ := broker.queue(ReceiptsQueue)
+	if !q.durable || q.args["x-dead-letter-exchange"] != DeadLetterExchange {
+		t.Errorf("queue is durable = %v with %v, want durable with the dead letter exchange", q.durable, q.args)
+	}
+	if Ids := broker.messageIds(ReceiptsQueue); !slices.Equal(Ids, []string{"a", "b", "c"}) {
+		t.Errorf("%v in the queue after migrating, want a, b and c in order", Ids)
+	}
+	for _, msg := range q.messages {
+		if msg.props.DeliveryMode != amqp.Persistent {
+			t.Errorf("receipt %s was moved as delivery mode %d, want persistent", msg.props.MessageId, msg.props.DeliveryMode)
+		}
+	}
+	if _, ok := broker.queue(migrationQueueName(ReceiptsQueue)); ok {
+		t.Error("the holding queue is still there")
+	}
+}
+
+func TestDeclareTopologyRecoversInterruptedMigration(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(broker *fakeBroker)
+		want  []string
+	}{
+		{
+			// The old queue was deleted but not declared again
+			name: "after the delete",
+			setup: func(broker *fakeBroker) {
+				broker.addQueue(migrationQueueName(ReceiptsQueue), true, nil, receipts("a", "b")...)
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			// The server published to the old queue before the consumer got to finish
+			name: "before the delete",
+			setup: func(broker *fakeBroker) {
+				broker.addQueue(migrationQueueName(ReceiptsQueue), true, nil, receipts("a", "b")...)
+				broker.addQueue(ReceiptsQueue, false, nil, receipts("c")...)
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			// The queue was declared again, but the receipts weren't all moved back
+			name: "while moving back",
+			setup: func(broker *fakeBroker) {
+				broker.addQueue(migrationQueueName(ReceiptsQueue), true, nil, receipts("b")...)
+				broker.addQueue(ReceiptsQueue, true, amqp.Table{"x-dead-letter-exchange": DeadLetterExchange}, receipts("a")...)
+			},
+			want: []string{"a", "b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker := newFakeBroker(t)
+			test.setup(broker)
+			dialTopology(t, broker)
+
+			if q, _ := broker.queue(ReceiptsQueue); !q.durable {
+				t.Error("the queue isn't durable after recovering")
+			}
+			if Ids := broker.messageIds(ReceiptsQueue); !slices.Equal(Ids, test.want) {
+				t.Errorf("%v in the queue after recovering, want %v", Ids, test.want)
+			}
+			if _, ok := broker.queue(migrationQueueName(ReceiptsQueue)); ok {
+				t.Error("the holding queue is still there")
+			}
+		})
+	}
+}
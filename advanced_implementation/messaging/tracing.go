@@ -0,0 +1,43 @@
+package messaging
+
+import (
+	"context"
+	"maps"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"restGo/tracing"
+)
+
+// startPublish starts the span of a publish and passes its trace context on in a copy of the message's headers
+func startPublish(ctx context.Context, system, destination string, msg Message) (context.Context, Message, trace.Span) {
+	ctx, span := tracing.Tracer().Start(ctx, "publish "+destination,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", system),
+			attribute.String("messaging.destination.name", destination),
+			attribute.String("messaging.message.id", msg.Id),
+		),
+	)
+	headers := maps.Clone(msg.Headers)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	tracing.Inject(ctx, headers)
+	msg.Headers = headers
+	return ctx, msg, span
+}
+
+// startProcess starts the span of handling a message as a child of the publish, and makes the log lines about it carry its
+// request ID
+func startProcess(ctx context.Context, system, destination string, msg Message) (context.Context, trace.Span) {
+	ctx = tracing.Extract(messageContext(ctx, msg), msg.Headers)
+	return tracing.Tracer().Start(ctx, "process "+destination,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", system),
+			attribute.String("messaging.destination.name", destination),
+			attribute.String("messaging.message.id", msg.Id),
+		),
+	)
+}
@@ -0,0 +1,58 @@
+package messaging
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// workerPool runs handlers on a fixed number of goroutines. Messages for the same receipt Id always go to the same worker, so
+// they are handled one after another in the order they arrived while different receipts are handled in parallel
+type workerPool struct {
+	queues []chan func()
+	wg     sync.WaitGroup
+
+	mu      sync.RWMutex
+	stopped bool
+}
+
+func newWorkerPool(workers int) *workerPool {
+	pool := &workerPool{queues: make([]chan func(), max(workers, 1))}
+	for i := range pool.queues {
+		pool.queues[i] = make(chan func(), 1)
+		pool.wg.Add(1)
+		go func(queue chan func()) {
+			defer pool.wg.Done()
+			for job := range queue {
+				job()
+			}
+		}(pool.queues[i])
+	}
+	return pool
+}
+
+// dispatch blocks while the worker for that Id is busy, which holds back the deliveries behind it. With a prefetch limit the
+// broker stops sending more until the workers catch up. Once the pool is stopped jobs are dropped, and the broker redelivers
+// the messages they were for since nothing settled them
+func (p *workerPool) dispatch(Id string, job func()) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		return
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(Id))
+	p.queues[hash.Sum32()%uint32(len(p.queues))] <- job
+}
+
+// stop waits for the jobs already dispatched to finish
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"restGo/alerting"
+	"restGo/logging"
+)
+
+type writeRequest struct {
+	key   []byte
+	value []byte
+	done  chan error
+}
+
+// batchWriter collects the writes of every worker and stores them together with one WriteBatch, so parallel workers don't each
+// pay for a transaction and a disk sync. Nothing waits on a timer: whatever queued up while the last batch was being flushed
+// makes the next one, so a single worker writes just as fast as before. A write only returns once its batch is flushed, so a
+// message is never acked before its receipt is on disk
+type batchWriter struct {
+	db       *badger.DB
+	requests chan writeRequest
+	maxSize  int
+}
+
+func newBatchWriter(db *badger.DB, maxSize int) *batchWriter {
+	w := &batchWriter{
+		db:       db,
+		requests: make(chan writeRequest, maxSize),
+		maxSize:  maxSize,
+	}
+	go w.run()
+	return w
+}
+
+// Write blocks until the batch holding the write is flushed, and returns the batch's error
+func (w *batchWriter) Write(key, value []byte) error {
+	request := writeRequest{key: key, value: value, done: make(chan error, 1)}
+	w.requests <- request
+	return <-request.done
+}
+
+func (w *batchWriter) run() {
+	for first := range w.requests {
+		batch := []writeRequest{first}
+	collect:
+		for len(batch) < w.maxSize {
+			select {
+			case request := <-w.requests:
+				batch = append(batch, request)
+			default:
+				break collect
+			}
+		}
+		start := time.Now()
+		errs := w.write(batch)
+		writeDurationMetric.Observe(time.Since(start).Seconds())
+		writeSizeMetric.Observe(float64(len(batch)))
+		for i, request := range batch {
+			request.done <- errs[i]
+		}
+	}
+}
+
+// write stores a batch and returns each request's error. A WriteBatch fails as a whole, so when it does the requests are written
+// again one transaction each, and only the ones that fail on their own (e.g. with a key Badger refuses) get an error. Writing a
+// receipt that the failed batch did store is harmless, it is the same value
+func (w *batchWriter) write(batch []writeRequest) []error {
+	errs := make([]error, len(batch))
+	err := w.flush(batch)
+	if err != nil && len(batch) > 1 {
+		slog.Warn("Failed to write a batch of receipts, writing them one at a time", "count", len(batch), logging.Err(err))
+		for i, request := range batch {
+			errs[i] = w.db.Update(func(txn *badger.Txn) error {
+				return txn.Set(request.key, request.value)
+			})
+		}
+	} else {
+		errs[0] = err
+	}
+	for i, err := range errs {
+		if err != nil {
+			alerting.Raise(context.Background(), alerting.StoreFailed, "Failed to write a receipt", logging.Receipt(string(batch[i].key)), logging.Err(err))
+		}
+	}
+	return errs
+}
+
+func (w *batchWriter) flush(batch []writeRequest) error {
+	wb := w.db.NewWriteBatch()
+	for _, request := range batch {
+		if err := wb.Set(request.key, request.value); err != nil {
+			wb.Cancel() // Only when Flush isn't reached, calling both panics
+			return err
+		}
+	}
+	return wb.Flush()
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestWriteFailsOnlyTheBadRequest(t *testing.T) {
+	db := openDB(t, t.TempDir(), false)
+	defer db.Close()
+	w := &batchWriter{db: db, maxSize: 10}
+	batch := []writeRequest{
+		{key: []byte("a"), value: []byte("1")},
+		{key: []byte("!badger!b"), value: []byte("2")},
+		{key: []byte("c"), value: []byte("3")},
+	}
+
+	errs := w.write(batch)
+	if errs[0] != nil || errs[2] != nil {
+		t.Errorf("good writes failed: %v, %v", errs[0], errs[2])
+	}
+	if !errors.Is(errs[1], badger.ErrInvalidKey) {
+		t.Errorf("bad write error = %v, want %v", errs[1], badger.ErrInvalidKey)
+	}
+	for _, key := range []string{"a", "c"} {
+		if body := storedBody(t, db, key); len(body) != 1 {
+			t.Errorf("%s = %q, want it stored", key, body)
+		}
+	}
+}
+
+// The consumer's workers write in parallel, so the batch writer is measured with as many goroutines as the default
+// CONSUMER_WORKERS, against the one transaction per message the consumer used before
+const benchmarkWorkers = 8
+
+var benchmarkValue = []byte(`{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","total":"35.35","points":28}`)
+
+func BenchmarkOneTransactionPerMessage(b *testing.B) {
+	db := openBenchmarkDB(b)
+	var next atomic.Int64
+	runWorkers(b, func() error {
+		key := []byte(fmt.Sprint(next.Add(1)))
+		return db.Update(func(txn *badger.Txn) error {
+			return txn.Set(key, benchmarkValue)
+		})
+	})
+}
+
+func BenchmarkBatchWriter(b *testing.B) {
+	db := openBenchmarkDB(b)
+	w := newBatchWriter(db, 100)
+	var next atomic.Int64
+	runWorkers(b, func() error {
+		return w.Write([]byte(fmt.Sprint(next.Add(1))), benchmarkValue)
+	})
+}
+
+func openBenchmarkDB(b *testing.B) *badger.DB {
+	db, err := badger.Open(badger.DefaultOptions(b.TempDir()).WithLogger(nil))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return db
+}
+
+// runWorkers calls write b.N times in all from benchmarkWorkers goroutines
+func runWorkers(b *testing.B, write func() error) {
+	var remaining atomic.Int64
+	remaining.Store(int64(b.N))
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for range benchmarkWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for remaining.Add(-1) >= 0 {
+				if err := write(); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,53 @@
+package pipeline
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Exposed by the consumer at /metrics on METRICS_LISTEN, next to the retry and dead letter counts of the messaging package
+var (
+	processedMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "receipts_processed_total",
+		Help: "Receipts handled by the consumer, by result (stored, poison or transient) and the stage they got to",
+	}, []string{"result", "stage"})
+	lagMetric = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "receipts_consumer_lag_seconds",
+		Help:    "Time from the server publishing a receipt to the consumer picking it up, including any retry delays",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 30, 60, 300, 900},
+	})
+	pointsMetric = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "receipt_points",
+		Help:    "Points awarded to the receipts stored",
+		Buckets: []float64{10, 25, 50, 75, 100, 150, 200, 300, 500},
+	})
+	writeDurationMetric = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "badger_write_batch_duration_seconds",
+		Help:    "How long a WriteBatch of receipts took to flush to BadgerDB",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+	writeSizeMetric = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "badger_write_batch_size",
+		Help:    "Receipts per WriteBatch",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 8),
+	})
+)
+
+// Collectors returns the metrics above for the consumer to register. Unlike the other packages' they aren't registered on import,
+// since the server has a receipt_points of its own and its tests run the pipeline in the same process
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{processedMetric, lagMetric, pointsMetric, writeDurationMetric, writeSizeMetric}
+}
+
+func recordProcessed(err error) {
+	var failure *processingError
+	switch {
+	case err == nil:
+		processedMetric.WithLabelValues("stored", string(persistStage)).Inc()
+	case errors.As(err, &failure) && failure.poison:
+		processedMetric.WithLabelValues("poison", string(failure.stage)).Inc()
+	case errors.As(err, &failure):
+		processedMetric.WithLabelValues("transient", string(failure.stage)).Inc()
+	}
+}
@@ -0,0 +1,181 @@
+// Package pipeline is what the consumer does with every receipt it is handed: decode, validate, score and store it in BadgerDB
+// through a shared batch writer
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"restGo/logging"
+	"restGo/messaging"
+	"restGo/scoring"
+	"restGo/tracing"
+)
+
+// Every message goes through the same stages: decode -> validate -> score -> persist, and the message bus settles it afterwards
+// A stage that fails says whether the message itself is the problem (poison) or whether trying again later could work (transient):
+//   - poison messages are parked by the message bus straight away, since no amount of retrying fixes them
+//   - transient failures are retried after each of the retry delays and only parked once those are used up
+type stage string
+
+const (
+	decodeStage   stage = "decode"
+	validateStage stage = "validate"
+	scoreStage    stage = "score"
+	persistStage  stage = "persist"
+)
+
+type processingError struct {
+	stage  stage
+	poison bool
+	err    error
+}
+
+func (e *processingError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.stage, e.err)
+}
+
+func (e *processingError) Unwrap() error {
+	return e.err
+}
+
+// Is lets the message bus tell poison errors apart with errors.Is(err, messaging.ErrPoison)
+func (e *processingError) Is(target error) bool {
+	return target == messaging.ErrPoison && e.poison
+}
+
+func poison(s stage, err error) error {
+	return &processingError{stage: s, poison: true, err: err}
+}
+
+func transient(s stage, err error) error {
+	return &processingError{stage: s, poison: false, err: err}
+}
+
+// Badger's own keys start with this, so a write under such a key fails with ErrInvalidKey
+const reservedKeyPrefix = "!badger!"
+
+// Pipeline stores receipts in db
+type Pipeline struct {
+	db     *badger.DB
+	writer *batchWriter
+}
+
+// New starts a pipeline that writes to db in WriteBatches of up to batchSize receipts instead of one transaction each
+func New(db *badger.DB, batchSize int) *Pipeline {
+	return &Pipeline{db: db, writer: newBatchWriter(db, batchSize)}
+}
+
+// decode turns the message body into a receipt. The server only ever publishes receipts, so anything else is poison
+func decode(body []byte) (scoring.Receipt, error) {
+	var receipt scoring.Receipt
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&receipt); err != nil {
+		return scoring.Receipt{}, poison(decodeStage, err)
+	}
+	return receipt, nil
+}
+
+// validate runs the same checks as the server, so nothing reaches the database just because it was put on the queue.
+// Without an Id it would also be written under an empty key, and Badger refuses keys with its own prefix
+func validate(receipt scoring.Receipt) error {
+	if receipt.Id == "" {
+		return poison(validateStage, errors.New("receipt has no Id"))
+	}
+	if strings.HasPrefix(receipt.Id, reservedKeyPrefix) {
+		return poison(validateStage, fmt.Errorf("receipt Id starts with %s, which BadgerDB keeps for itself", reservedKeyPrefix))
+	}
+	if reason := scoring.ValidateReceiptFields(receipt); reason != "" {
+		return poison(validateStage, &scoring.InvalidReceiptError{Reason: reason})
+	}
+	return nil
+}
+
+// score calculates the points once so the stored record is authoritative and readers never have to
+func score(ctx context.Context, receipt scoring.Receipt) (scoring.Record, error) {
+	_, span := tracing.Tracer().Start(ctx, "scoring.Score")
+	record, err := scoring.Score(scoring.ActiveRuleset(), receipt)
+	tracing.End(span, err)
+	if err != nil {
+		return scoring.Record{}, poison(scoreStage, err)
+	}
+	return record, nil
+}
+
+// Errors caused by the receipt itself, so retrying can never help. Anything else (conflicts, blocked writes, disk trouble)
+// is treated as transient
+func isPermanent(err error) bool {
+	return errors.Is(err, badger.ErrEmptyKey) || errors.Is(err, badger.ErrInvalidKey) || errors.Is(err, badger.ErrTxnTooBig)
+}
+
+// persist writes a record through the shared batch writer. Redelivered messages find their own copy already stored and are
+// skipped. Messages for the same Id are never handled in parallel, so nothing can slip in between the check and the write
+func (p *Pipeline) persist(ctx context.Context, record scoring.Record) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return poison(persistStage, err)
+	}
+	stored := false
+	p.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(record.Id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			stored = bytes.Equal(v, body)
+			return nil
+		})
+	})
+	if stored {
+		slog.DebugContext(ctx, "Receipt is already stored", logging.Receipt(record.Id))
+		return nil // Already written by an earlier delivery of the same message
+	}
+	_, span := tracing.Tracer().Start(ctx, "badger.WriteBatch", trace.WithAttributes(attribute.String("receipt.id", record.Id)))
+	err = p.writer.Write([]byte(record.Id), body)
+	tracing.End(span, err)
+	if err == nil {
+		pointsMetric.Observe(float64(record.Points))
+		slog.InfoContext(ctx, "Stored receipt", logging.Receipt(record.Id), "points", record.Points)
+		return nil
+	}
+	if isPermanent(err) {
+		return poison(persistStage, err)
+	}
+	return transient(persistStage, err)
+}
+
+// process runs the stages up to and including persist
+func (p *Pipeline) process(ctx context.Context, body []byte) error {
+	receipt, err := decode(body)
+	if err != nil {
+		return err
+	}
+	if err := validate(receipt); err != nil {
+		return err
+	}
+	record, err := score(ctx, receipt)
+	if err != nil {
+		return err
+	}
+	return p.persist(ctx, record)
+}
+
+// Handle is the messaging.Handler for receipts. The message bus acks, retries or parks the message depending on the result
+func (p *Pipeline) Handle(ctx context.Context, msg messaging.Message) error {
+	if published, err := time.Parse(time.RFC3339Nano, msg.Headers[messaging.PublishedAtHeader]); err == nil {
+		lagMetric.Observe(time.Since(published).Seconds())
+	}
+	err := p.process(ctx, msg.Body)
+	recordProcessed(err)
+	return err
+}
@@ -0,0 +1,208 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"restGo/messaging"
+	"restGo/scoring"
+)
+
+func validReceipt() scoring.Receipt {
+	return scoring.Receipt{
+		Id:           "abc",
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []scoring.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	}
+}
+
+// checkFailure fails the test unless err is nil when want is "", or failed in the stage want with the given classification
+func checkFailure(t *testing.T, err error, want stage, wantPoison bool) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Fatalf("error = %v, want nil", err)
+		}
+		return
+	}
+	var failure *processingError
+	if !errors.As(err, &failure) {
+		t.Fatalf("error = %v, want a failure in the %s stage", err, want)
+	}
+	if failure.stage != want {
+		t.Errorf("failed in the %s stage, want %s", failure.stage, want)
+	}
+	if failure.poison != wantPoison || errors.Is(err, messaging.ErrPoison) != wantPoison {
+		t.Errorf("poison = %v and errors.Is(err, ErrPoison) = %v, want %v", failure.poison, errors.Is(err, messaging.ErrPoison), wantPoison)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	valid, _ := json.Marshal(validReceipt())
+	tests := []struct {
+		name string
+		body string
+		want stage
+	}{
+		{"receipt", string(valid), ""},
+		{"not JSON", "receipt", decodeStage},
+		{"empty", "", decodeStage},
+		{"unknown field", `{"Id": "abc", "cashier": "Bob"}`, decodeStage},
+		{"number where a string goes", `{"Id": "abc", "total": 6.49}`, decodeStage},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receipt, err := decode([]byte(test.body))
+			checkFailure(t, err, test.want, true)
+			if test.want == "" && receipt.Id != "abc" {
+				t.Errorf("decoded Id %q, want abc", receipt.Id)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*scoring.Receipt)
+		want   stage
+	}{
+		{"valid", func(r *scoring.Receipt) {}, ""},
+		{"no Id", func(r *scoring.Receipt) { r.Id = "" }, validateStage},
+		{"reserved Id", func(r *scoring.Receipt) { r.Id = "!badger!head" }, validateStage},
+		{"no retailer", func(r *scoring.Receipt) { r.Retailer = "" }, validateStage},
+		{"bad date", func(r *scoring.Receipt) { r.PurchaseDate = "01/01/2022" }, validateStage},
+		{"bad total", func(r *scoring.Receipt) { r.Total = "NaN" }, validateStage},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receipt := validReceipt()
+			test.change(&receipt)
+			checkFailure(t, validate(receipt), test.want, true)
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	record, err := score(context.Background(), validReceipt())
+	checkFailure(t, err, "", false)
+	// 6 for the letters of Target and 6 for the odd day
+	if record.Points != 12 || record.RulesetVersion != scoring.ActiveRuleset().Version || record.Id != "abc" {
+		t.Errorf("score() = %+v, want 12 points under the active ruleset", record)
+	}
+
+	invalid := validReceipt()
+	invalid.PurchaseTime = "25:01"
+	_, err = score(context.Background(), invalid)
+	checkFailure(t, err, scoreStage, true)
+}
+
+func openDB(t *testing.T, dir string, readOnly bool) *badger.DB {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions(dir).WithReadOnly(readOnly).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func storedBody(t *testing.T, db *badger.DB, key string) []byte {
+	t.Helper()
+	var body []byte
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		body, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("reading %s: %v", key, err)
+	}
+	return body
+}
+
+func TestPersist(t *testing.T) {
+	db := openDB(t, t.TempDir(), false)
+	defer db.Close()
+	p := New(db, 10)
+	record, err := score(context.Background(), validReceipt())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		record     func() scoring.Record
+		want       stage
+		wantPoison bool
+	}{
+		{"new receipt", func() scoring.Record { return record }, "", false},
+		{"redelivered receipt", func() scoring.Record { return record }, "", false},
+		{"rescored receipt", func() scoring.Record { r := record; r.Points++; return r }, "", false},
+		{"reserved key", func() scoring.Record { r := record; r.Id = "!badger!head"; return r }, persistStage, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := test.record()
+			checkFailure(t, p.persist(context.Background(), r), test.want, test.wantPoison)
+			if test.want == "" {
+				want, _ := json.Marshal(r)
+				if got := storedBody(t, db, r.Id); string(got) != string(want) {
+					t.Errorf("stored %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+// A write the database refuses for reasons of its own may work later, so it is retried rather than parked
+func TestPersistTransient(t *testing.T) {
+	dir := t.TempDir()
+	openDB(t, dir, false).Close()
+	db := openDB(t, dir, true)
+	defer db.Close()
+	record, err := score(context.Background(), validReceipt())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = New(db, 10).persist(context.Background(), record)
+	checkFailure(t, err, persistStage, false)
+	if !errors.Is(err, badger.ErrReadOnlyTxn) {
+		t.Errorf("error = %v, want it to wrap %v", err, badger.ErrReadOnlyTxn)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	db := openDB(t, t.TempDir(), false)
+	defer db.Close()
+	p := New(db, 10)
+	valid, _ := json.Marshal(validReceipt())
+	noId := validReceipt()
+	noId.Id = ""
+	withoutId, _ := json.Marshal(noId)
+
+	tests := []struct {
+		name string
+		body []byte
+		want stage
+	}{
+		{"stored", valid, ""},
+		{"stored again", valid, ""},
+		{"garbage", []byte("{"), decodeStage},
+		{"no Id", withoutId, validateStage},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := p.Handle(context.Background(), messaging.Message{Id: "abc", Body: test.body})
+			checkFailure(t, err, test.want, true)
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package scoring
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePurchaseDate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // YYYY-MM-DD, empty if the date is invalid
+	}{
+		{"2022-01-02", "2022-01-02"},
+		{"2022-1-2", "2022-01-02"},
+		{"2022-12-31", "2022-12-31"},
+		{"2024-02-29", "2024-02-29"},
+		{"2023-02-29", ""},
+		{"2022-02-30", ""},
+		{"2022-13-01", ""},
+		{"2022-00-10", ""},
+		{"2022-01-00", ""},
+		{"22-01-02", ""},
+		{"2022/01/02", ""},
+		{"2022-01-02T10:00", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			date, err := parsePurchaseDate(test.input)
+			switch {
+			case test.want == "" && err == nil:
+				t.Errorf("parsePurchaseDate(%q) = %s, want an error", test.input, date.Format(time.DateOnly))
+			case test.want != "" && err != nil:
+				t.Errorf("parsePurchaseDate(%q) failed: %v", test.input, err)
+			case test.want != "" && date.Format(time.DateOnly) != test.want:
+				t.Errorf("parsePurchaseDate(%q) = %s, want %s", test.input, date.Format(time.DateOnly), test.want)
+			}
+		})
+	}
+}
+
+func TestParsePurchaseTime(t *testing.T) {
+	valid := []struct {
+		input string
+		want  timeOfDay
+	}{
+		// 24-hour clock, 0:00 to 23:59
+		{"0:00", timeOfDay{0, 0, 0}},
+		{"00:00", timeOfDay{0, 0, 0}},
+		{"13:01", timeOfDay{13, 1, 0}},
+		{"23:59", timeOfDay{23, 59, 0}},
+		// One or two digits in every part
+		{"9:5", timeOfDay{9, 5, 0}},
+		{"09:05", timeOfDay{9, 5, 0}},
+		{"7:05:9", timeOfDay{7, 5, 9}},
+		// Seconds
+		{"14:30:59", timeOfDay{14, 30, 59}},
+		{"00:00:00", timeOfDay{0, 0, 0}},
+		// 12-hour clock, where 12 AM is midnight and 12 PM is noon
+		{"12:00 AM", timeOfDay{0, 0, 0}},
+		{"12:00am", timeOfDay{0, 0, 0}},
+		{"12:59 AM", timeOfDay{0, 59, 0}},
+		{"1:00 AM", timeOfDay{1, 0, 0}},
+		{"11:59 AM", timeOfDay{11, 59, 0}},
+		{"12:00 PM", timeOfDay{12, 0, 0}},
+		{"12:30pm", timeOfDay{12, 30, 0}},
+		{"1:00 PM", timeOfDay{13, 0, 0}},
+		{"2:30PM", timeOfDay{14, 30, 0}},
+		{"11:59:59 pm", timeOfDay{23, 59, 59}},
+		{"3:07 Pm", timeOfDay{15, 7, 0}},
+	}
+	for _, test := range valid {
+		t.Run(test.input, func(t *testing.T) {
+			got, err := parsePurchaseTime(test.input)
+			if err != nil {
+				t.Fatalf("parsePurchaseTime(%q) failed: %v", test.input, err)
+			}
+			if got != test.want {
+				t.Errorf("parsePurchaseTime(%q) = %+v, want %+v", test.input, got, test.want)
+			}
+		})
+	}
+
+	invalid := []string{
+		// The 24-hour clock stops at 23:59, and the 12-hour clock goes from 1 to 12
+		"24:00", "24:00:00", "13:00 PM", "0:00 AM",
+		"12:60", "12:00:60",
+		// Hours and minutes are both required, seconds are optional
+		"12", "1:2:3:4",
+		// One or two digits per part and no signs or spaces inside
+		"123:00", "12:000", "-1:00", "+1:00", "1 :00",
+		// Only AM or PM, with at most one space before it
+		"12:00 XM", "12:00 P", "12:00 P.M.", "12:00 PMM", "12:00  PM", "12:00 AM PM", "AM",
+		"",
+	}
+	for _, input := range invalid {
+		t.Run(input, func(t *testing.T) {
+			if got, err := parsePurchaseTime(input); err == nil {
+				t.Errorf("parsePurchaseTime(%q) = %+v, want an error", input, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package scoring
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+func calculateRetailerNamePoints(rules Ruleset, retailerName string) int {
+	count := 0
+	for _, char := range retailerName {
+		if unicode.IsLetter(char) || unicode.IsNumber(char) {
+			count += 1
+		}
+	}
+	return count * rules.PointsPerRetailerChar
+}
+
+func calculateTotalPricePoints(rules Ruleset, totalPrice string) (int, error) {
+	total, err := strconv.ParseFloat(totalPrice, 64)
+	if err != nil {
+		return -1, errors.New("error in converting total price to float")
+	}
+	points := 0
+	if math.Mod(total, 1) == 0 {
+		points += rules.RoundTotalPoints
+	}
+	if math.Mod(total, 0.25) == 0 {
+		points += rules.QuarterTotalPoints
+	}
+	return points, nil
+}
+
+func calculateItemCountPoints(rules Ruleset, items []Item) int {
+	itemsCount := len(items)
+	return (itemsCount / 2) * rules.PointsPerItemPair
+}
+
+func calculateItemDescriptionPoints(rules Ruleset, items []Item) (int, error) {
+	total := 0
+	for _, item := range items {
+		shortDescription := item.ShortDescription
+		trimmedString := strings.TrimSpace(shortDescription)
+		if len(trimmedString)%3 == 0 {
+			price, err := strconv.ParseFloat(item.Price, 64)
+			if err != nil {
+				return -1, errors.New("error in converting price of an item to float")
+			}
+			total += int(math.Ceil(price * rules.DescriptionMultiplier))
+		}
+	}
+	return total, nil
+}
+
+func calculatePurchaseDayPoints(rules Ruleset, purchaseDay int) int {
+	if purchaseDay%2 == 1 {
+		return rules.OddDayPoints
+	}
+	return 0
+}
+
+func calculatePurchaseDatePoints(rules Ruleset, purchased time.Time) int {
+	return calculatePurchaseDayPoints(rules, purchased.Day())
+}
+
+func calculatePurchaseTimePoints(rules Ruleset, purchased time.Time) int {
+	if purchased.Hour() >= rules.AfternoonStartHour && purchased.Hour() < rules.AfternoonEndHour {
+		return rules.AfternoonPoints
+	}
+	return 0
+}
+
+// CalculatePoints adds up the points a receipt is awarded under every rule of the ruleset
+func CalculatePoints(rules Ruleset, receipt Receipt) (int, error) {
+	totalPoints := 0
+
+	retailerNamePoints := calculateRetailerNamePoints(rules, receipt.Retailer)
+
+	totalPricePoints, err := calculateTotalPricePoints(rules, receipt.Total)
+	if err != nil {
+		return -1, err
+	}
+
+	itemCountPoints := calculateItemCountPoints(rules, receipt.Items)
+
+	itemDescriptionPoints, err := calculateItemDescriptionPoints(rules, receipt.Items)
+	if err != nil {
+		return -1, err
+	}
+
+	// The day and the hour are the store's, whichever timezone the receipt was written in
+	purchased, err := ActiveTimezones().PurchasedAt(receipt)
+	if err != nil {
+		return -1, err
+	}
+	itemPurchaseDatePoints := calculatePurchaseDatePoints(rules, purchased)
+	itemPurhcaseTimePoints := calculatePurchaseTimePoints(rules, purchased)
+	// Print the points for each category for debugging
+	// fmt.Println("Retailer Name Points: ", retailerNamePoints)
+	// fmt.Println("Total Price Points: ", totalPricePoints)
+	// fmt.Println("Item Count Points: ", itemCountPoints)
+	// fmt.Println("Item Description Points: ", itemDescriptionPoints)
+	// fmt.Println("Item Purchase Date Points: ", itemPurchaseDatePoints)
+	// fmt.Println("Item Purchase Time Points: ", itemPurhcaseTimePoints)
+
+	totalPoints = retailerNamePoints + totalPricePoints + itemCountPoints +
+		itemDescriptionPoints + itemPurchaseDatePoints + itemPurhcaseTimePoints
+	return totalPoints, nil
+}
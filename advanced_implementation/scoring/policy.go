@@ -0,0 +1,54 @@
+package scoring
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Policy is what a well formed receipt also has to meet to be believed. The server applies it when a receipt is submitted.
+// Scoring and rescoring don't, so a stored receipt isn't turned away later for having grown old
+type Policy struct {
+	MaxAge    time.Duration // How long before it is submitted a purchase can have happened. Zero means no limit
+	MaxFuture time.Duration // How far ahead of the server's clock a purchase can be, for store clocks that are a little off
+	MaxTotal  float64       // Applies to the total and to each item's price
+	MaxItems  int
+}
+
+// DefaultPolicy allows receipts of any age, since old receipts were always accepted, and up to a day ahead, since a receipt
+// without a timezone may be read in the wrong one
+var DefaultPolicy = Policy{
+	MaxFuture: 24 * time.Hour,
+	MaxTotal:  10000,
+	MaxItems:  500,
+}
+
+// Rejection says which field of a receipt broke the policy and how. Both are safe to show the client
+type Rejection struct {
+	Rule   string `json:"rule"` // too_new, too_old, too_many_items, total_too_high or price_too_high
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Check returns the first rule the receipt breaks, or nil. It expects a receipt that passed ValidateReceiptFields,
+// purchased as returned by Timezones.PurchasedAt and now as the time it was submitted
+func (p Policy) Check(receipt Receipt, purchased, now time.Time) *Rejection {
+	if purchased.After(now.Add(p.MaxFuture)) {
+		return &Rejection{Rule: "too_new", Field: "purchaseDate", Reason: fmt.Sprintf("the purchase is more than %s in the future", p.MaxFuture)}
+	}
+	if p.MaxAge > 0 && purchased.Before(now.Add(-p.MaxAge)) {
+		return &Rejection{Rule: "too_old", Field: "purchaseDate", Reason: fmt.Sprintf("the purchase is more than %s old", p.MaxAge)}
+	}
+	if len(receipt.Items) > p.MaxItems {
+		return &Rejection{Rule: "too_many_items", Field: "items", Reason: fmt.Sprintf("a receipt can have at most %d items", p.MaxItems)}
+	}
+	if total, _ := strconv.ParseFloat(receipt.Total, 64); total > p.MaxTotal {
+		return &Rejection{Rule: "total_too_high", Field: "total", Reason: fmt.Sprintf("the total can be at most %.2f", p.MaxTotal)}
+	}
+	for i, item := range receipt.Items {
+		if price, _ := strconv.ParseFloat(item.Price, 64); price > p.MaxTotal {
+			return &Rejection{Rule: "price_too_high", Field: fmt.Sprintf("items[%d].price", i), Reason: fmt.Sprintf("a price can be at most %.2f", p.MaxTotal)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package scoring
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	limited := Policy{MaxAge: 30 * 24 * time.Hour, MaxFuture: time.Hour, MaxTotal: 100, MaxItems: 2}
+	tests := []struct {
+		name      string
+		policy    Policy
+		purchased time.Time
+		change    func(*Receipt)
+		want      string // The rule broken, empty when the receipt passes
+	}{
+		{"valid", limited, now.Add(-time.Hour), func(r *Receipt) {}, ""},
+		{"at the age limit", limited, now.Add(-30 * 24 * time.Hour), func(r *Receipt) {}, ""},
+		{"too old", limited, now.Add(-31 * 24 * time.Hour), func(r *Receipt) {}, "too_old"},
+		{"any age by default", DefaultPolicy, now.AddDate(-2, 0, 0), func(r *Receipt) {}, ""},
+		{"within the skew", limited, now.Add(59 * time.Minute), func(r *Receipt) {}, ""},
+		{"beyond the skew", limited, now.Add(61 * time.Minute), func(r *Receipt) {}, "too_new"},
+		{"no skew allowed", Policy{MaxTotal: 100, MaxItems: 2}, now.Add(time.Second), func(r *Receipt) {}, "too_new"},
+		{"at the item limit", limited, now, func(r *Receipt) { r.Items = append(r.Items, r.Items[0]) }, ""},
+		{"too many items", limited, now, func(r *Receipt) { r.Items = append(r.Items, r.Items[0], r.Items[0]) }, "too_many_items"},
+		{"at the total limit", limited, now, func(r *Receipt) { r.Total = "100.00" }, ""},
+		{"total too high", limited, now, func(r *Receipt) { r.Total = "100.01" }, "total_too_high"},
+		{"price too high", limited, now, func(r *Receipt) { r.Items[0].Price = "150.00"; r.Total = "50.00" }, "price_too_high"},
+		{"first rule broken wins", limited, now.Add(2 * time.Hour), func(r *Receipt) { r.Total = "500.00" }, "too_new"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receipt := validReceipt()
+			test.change(&receipt)
+			rejection := test.policy.Check(receipt, test.purchased, now)
+			switch {
+			case rejection == nil && test.want != "":
+				t.Errorf("Check() = nil, want %s", test.want)
+			case rejection != nil && rejection.Rule != test.want:
+				t.Errorf("Check() = %+v, want %q", rejection, test.want)
+			}
+		})
+	}
+}
+
+func TestPolicyRejectionNamesTheField(t *testing.T) {
+	receipt := validReceipt()
+	receipt.Items = append(receipt.Items, Item{ShortDescription: "TV", Price: "20000.00"})
+	rejection := DefaultPolicy.Check(receipt, time.Now(), time.Now())
+	if rejection == nil || rejection.Field != "items[1].price" || rejection.Reason != "a price can be at most 10000.00" {
+		t.Errorf("Check() = %+v, want items[1].price over 10000.00", rejection)
+	}
+}
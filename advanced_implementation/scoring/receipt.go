@@ -0,0 +1,51 @@
+// Package scoring holds the receipt types together with the validation and points rules, so the server and the consumer
+// always judge a receipt the same way
+package scoring
+
+import "time"
+
+// Declaring the structure of the receipt and item
+type Item struct {
+	ShortDescription string `json:"shortDescription"`
+	Price            string `json:"price"`
+}
+
+type Receipt struct {
+	Id           string `json:"Id"`
+	Retailer     string `json:"retailer"`
+	PurchaseDate string `json:"purchaseDate"`
+	PurchaseTime string `json:"purchaseTime"`
+	Items        []Item `json:"items"`
+	Total        string `json:"total"`
+	Timezone     string `json:"timezone,omitempty"` // Optional zone of purchaseDate and purchaseTime, see ParseTimezone
+}
+
+// Record is what the consumer stores in BadgerDB: the receipt along with the points it was awarded and the version of the
+// ruleset they were calculated with. Receipts stored before the consumer started scoring them have version 0
+type Record struct {
+	Receipt
+	Points         int       `json:"points"`
+	RulesetVersion int       `json:"rulesetVersion"`
+	PurchasedAt    time.Time `json:"purchasedAt"` // In UTC
+}
+
+// Stale reports whether the points have to be calculated again under the given ruleset
+func (r Record) Stale(rules Ruleset) bool {
+	return r.RulesetVersion != rules.Version
+}
+
+// Score validates a receipt and calculates its points under the given ruleset, returning the record to store
+func Score(rules Ruleset, receipt Receipt) (Record, error) {
+	if reason := ValidateReceiptFields(receipt); reason != "" {
+		return Record{}, &InvalidReceiptError{Reason: reason}
+	}
+	purchased, err := ActiveTimezones().PurchasedAt(receipt)
+	if err != nil {
+		return Record{}, err
+	}
+	points, err := CalculatePoints(rules, receipt)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Receipt: receipt, Points: points, RulesetVersion: rules.Version, PurchasedAt: purchased.UTC()}, nil
+}
@@ -0,0 +1,62 @@
+package scoring
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// RescoreStatus is a snapshot of a background rescore
+type RescoreStatus struct {
+	RulesetVersion int  `json:"rulesetVersion"`
+	Total          int  `json:"total"`
+	Rescored       int  `json:"rescored"`
+	Failed         int  `json:"failed"`
+	Finished       bool `json:"finished"`
+}
+
+func (s RescoreStatus) String() string {
+	return fmt.Sprintf("rescored %d/%d receipts (%d failed) for ruleset version %d", s.Rescored, s.Total, s.Failed, s.RulesetVersion)
+}
+
+// LogValue logs the status as a group of its numbers
+func (s RescoreStatus) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("ruleset_version", s.RulesetVersion),
+		slog.Int("total", s.Total),
+		slog.Int("rescored", s.Rescored),
+		slog.Int("failed", s.Failed),
+		slog.Bool("finished", s.Finished),
+	)
+}
+
+// RescoreProgress tracks a rescore running in the background so it can be reported while it runs
+type RescoreProgress struct {
+	mu     sync.Mutex
+	status RescoreStatus
+}
+
+func (p *RescoreProgress) Start(rulesetVersion, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status = RescoreStatus{RulesetVersion: rulesetVersion, Total: total, Finished: total == 0}
+}
+
+func (p *RescoreProgress) Add(rescored, failed int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.Rescored += rescored
+	p.status.Failed += failed
+}
+
+func (p *RescoreProgress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.Finished = true
+}
+
+func (p *RescoreProgress) Status() RescoreStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
@@ -0,0 +1,75 @@
+package scoring
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// Ruleset holds every number the points rules use. Bump the Version whenever a value changes so that records scored under
+// the old values are recognised as stale and rescored
+type Ruleset struct {
+	Version               int     `json:"version"`
+	PointsPerRetailerChar int     `json:"pointsPerRetailerChar"` // For every alphanumeric character in the retailer name
+	RoundTotalPoints      int     `json:"roundTotalPoints"`      // If the total is a round dollar amount
+	QuarterTotalPoints    int     `json:"quarterTotalPoints"`    // If the total is a multiple of 0.25
+	PointsPerItemPair     int     `json:"pointsPerItemPair"`     // For every two items
+	DescriptionMultiplier float64 `json:"descriptionMultiplier"` // Applied to the price when the description length is a multiple of 3
+	OddDayPoints          int     `json:"oddDayPoints"`          // If the purchase day is odd
+	AfternoonPoints       int     `json:"afternoonPoints"`       // If the purchase time is in the afternoon window
+	AfternoonStartHour    int     `json:"afternoonStartHour"`    // Inclusive
+	AfternoonEndHour      int     `json:"afternoonEndHour"`      // Exclusive
+}
+
+// DefaultRuleset is the ruleset from the assignment
+var DefaultRuleset = Ruleset{
+	Version:               1,
+	PointsPerRetailerChar: 1,
+	RoundTotalPoints:      50,
+	QuarterTotalPoints:    25,
+	PointsPerItemPair:     5,
+	DescriptionMultiplier: 0.2,
+	OddDayPoints:          6,
+	AfternoonPoints:       10,
+	AfternoonStartHour:    14,
+	AfternoonEndHour:      16,
+}
+
+var (
+	activeMu sync.RWMutex
+	active   = DefaultRuleset
+)
+
+// ActiveRuleset returns the ruleset new receipts are scored with
+func ActiveRuleset() Ruleset {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return active
+}
+
+func SetActiveRuleset(rules Ruleset) {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	active = rules
+}
+
+// LoadRuleset reads a ruleset from a JSON file. Values missing from the file keep their default
+func LoadRuleset(path string) (Ruleset, error) {
+	rules := DefaultRuleset
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Ruleset{}, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return Ruleset{}, err
+	}
+	if rules.Version <= 0 {
+		return Ruleset{}, errors.New("the ruleset version must be a positive number")
+	}
+	if rules != DefaultRuleset && rules.Version == DefaultRuleset.Version {
+		// Otherwise records scored with the default values would never be rescored
+		return Ruleset{}, errors.New("the ruleset changes the default values but keeps the default version. Bump the version")
+	}
+	return rules, nil
+}
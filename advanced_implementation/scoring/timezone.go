@@ -0,0 +1,149 @@
+package scoring
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // The containers don't ship a zoneinfo database
+)
+
+// A receipt's purchaseDate and purchaseTime are the wall clock in its optional timezone. The time based rules (odd day, afternoon
+// window) are judged on the store's wall clock, which is:
+//  1. The zone of the retailer in the retailer zone table, if it is listed
+//  2. Otherwise the receipt's own timezone
+//  3. Otherwise the default timezone
+//
+// A receipt without a timezone is read in the store's zone as well. Records keep the instant of the purchase in UTC
+
+// Timezones says where stores are when a receipt doesn't
+type Timezones struct {
+	Default   *time.Location
+	Retailers map[string]*time.Location // By lower case retailer name
+}
+
+var (
+	zonesMu sync.RWMutex
+	zones   = Timezones{Default: time.UTC}
+)
+
+func ActiveTimezones() Timezones {
+	zonesMu.RLock()
+	defer zonesMu.RUnlock()
+	return zones
+}
+
+func SetActiveTimezones(timezones Timezones) {
+	zonesMu.Lock()
+	defer zonesMu.Unlock()
+	zones = timezones
+}
+
+// LoadTimezones reads the default timezone and, if a path is given, a JSON file mapping retailer names to timezones, e.g.
+// {"Target": "America/Chicago", "M&M Corner Market": "-05:00"}
+func LoadTimezones(defaultZone, retailerZonesFile string) (Timezones, error) {
+	location, err := ParseTimezone(defaultZone)
+	if err != nil {
+		return Timezones{}, err
+	}
+	timezones := Timezones{Default: location, Retailers: map[string]*time.Location{}}
+	if retailerZonesFile == "" {
+		return timezones, nil
+	}
+	data, err := os.ReadFile(retailerZonesFile)
+	if err != nil {
+		return Timezones{}, err
+	}
+	var names map[string]string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return Timezones{}, err
+	}
+	for retailer, name := range names {
+		location, err := ParseTimezone(name)
+		if err != nil {
+			return Timezones{}, fmt.Errorf("retailer %q: %w", retailer, err)
+		}
+		timezones.Retailers[retailerKey(retailer)] = location
+	}
+	return timezones, nil
+}
+
+func retailerKey(retailer string) string {
+	return strings.ToLower(strings.TrimSpace(retailer))
+}
+
+// ParseTimezone reads an IANA zone name such as America/Chicago, UTC or Z, or a UTC offset such as +05:30, -0800 or +09
+func ParseTimezone(name string) (*time.Location, error) {
+	switch {
+	case name == "":
+		return nil, errors.New("the timezone is empty")
+	case name == "Z" || name == "UTC":
+		return time.UTC, nil
+	case name[0] == '+' || name[0] == '-':
+		return parseOffset(name)
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return location, nil
+}
+
+func parseOffset(name string) (*time.Location, error) {
+	digits := strings.Replace(name[1:], ":", "", 1)
+	if len(digits) != 2 && len(digits) != 4 {
+		return nil, fmt.Errorf("the UTC offset %q must look like +05, +0530 or +05:30", name)
+	}
+	hours, ok := smallNumber(digits[:2])
+	minutes, minutesOk := 0, true
+	if len(digits) == 4 {
+		minutes, minutesOk = smallNumber(digits[2:])
+	}
+	if !ok || !minutesOk || hours > 14 || minutes > 59 {
+		return nil, fmt.Errorf("the UTC offset %q is out of range", name)
+	}
+	seconds := hours*3600 + minutes*60
+	if name[0] == '-' {
+		seconds = -seconds
+	}
+	return time.FixedZone(name, seconds), nil
+}
+
+// storeLocation is the zone the time based rules are judged in, and the zone receipts without a timezone are read in
+func (t Timezones) storeLocation(receipt Receipt) (*time.Location, error) {
+	if location, ok := t.Retailers[retailerKey(receipt.Retailer)]; ok {
+		return location, nil
+	}
+	if receipt.Timezone != "" {
+		return ParseTimezone(receipt.Timezone)
+	}
+	return t.Default, nil
+}
+
+// PurchasedAt returns the moment of the purchase on the store's wall clock
+func (t Timezones) PurchasedAt(receipt Receipt) (time.Time, error) {
+	date, err := parsePurchaseDate(receipt.PurchaseDate)
+	if err != nil {
+		return time.Time{}, err
+	}
+	clock, err := parsePurchaseTime(receipt.PurchaseTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	store, err := t.storeLocation(receipt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	written := store
+	if receipt.Timezone != "" {
+		if written, err = ParseTimezone(receipt.Timezone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	// For a wall clock time skipped or repeated by a daylight saving change Go picks one side of it
+	purchased := time.Date(date.Year(), date.Month(), date.Day(), clock.Hour, clock.Minute, clock.Second, 0, written)
+	return purchased.In(store), nil
+}
@@ -0,0 +1,146 @@
+package scoring
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimezone(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantOffset int // Seconds east of UTC in January
+		wantErr    bool
+	}{
+		{"-05:00", -5 * 3600, false},
+		{"+0530", 5*3600 + 30*60, false},
+		{"+09", 9 * 3600, false},
+		{"-00:30", -30 * 60, false},
+		{"+14:00", 14 * 3600, false},
+		{"Z", 0, false},
+		{"UTC", 0, false},
+		{"America/Chicago", -6 * 3600, false},
+		{"", 0, true},
+		{"+5", 0, true},
+		{"+053", 0, true},
+		{"+15:00", 0, true},
+		{"+05:60", 0, true},
+		{"+0a:00", 0, true},
+		{"05:00", 0, true},
+		{"Local", 0, true},
+		{"Mars/Olympus", 0, true},
+	}
+	for _, test := range tests {
+		location, err := ParseTimezone(test.name)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseTimezone(%q) = %v, want an error", test.name, location)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTimezone(%q) = %v", test.name, err)
+			continue
+		}
+		if _, offset := time.Date(2022, 1, 1, 0, 0, 0, 0, location).Zone(); offset != test.wantOffset {
+			t.Errorf("ParseTimezone(%q) has offset %d, want %d", test.name, offset, test.wantOffset)
+		}
+	}
+}
+
+func mustParseTimezone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	location, err := ParseTimezone(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+func TestPurchasedAt(t *testing.T) {
+	timezones := Timezones{
+		Default:   mustParseTimezone(t, "-05:00"),
+		Retailers: map[string]*time.Location{"target": mustParseTimezone(t, "Asia/Tokyo")},
+	}
+	tests := []struct {
+		name      string
+		retailer  string
+		timezone  string
+		wantUTC   string // The moment of the purchase
+		wantStore string // The store's wall clock
+	}{
+		{"retailer zone", "Target", "", "2022-01-01T04:01:00Z", "2022-01-01 13:01"},
+		{"retailer zone in any case", "  TARGET ", "", "2022-01-01T04:01:00Z", "2022-01-01 13:01"},
+		{"retailer zone over the receipt's", "Target", "+00:00", "2022-01-01T13:01:00Z", "2022-01-01 22:01"},
+		{"receipt's zone", "Walgreens", "+05:30", "2022-01-01T07:31:00Z", "2022-01-01 13:01"},
+		{"receipt's zone by name", "Walgreens", "Europe/Berlin", "2022-01-01T12:01:00Z", "2022-01-01 13:01"},
+		{"default zone", "Walgreens", "", "2022-01-01T18:01:00Z", "2022-01-01 13:01"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receipt := validReceipt()
+			receipt.Retailer = test.retailer
+			receipt.Timezone = test.timezone
+			purchased, err := timezones.PurchasedAt(receipt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := purchased.UTC().Format(time.RFC3339); got != test.wantUTC {
+				t.Errorf("PurchasedAt() = %s in UTC, want %s", got, test.wantUTC)
+			}
+			if got := purchased.Format("2006-01-02 15:04"); got != test.wantStore {
+				t.Errorf("PurchasedAt() = %s on the store's clock, want %s", got, test.wantStore)
+			}
+		})
+	}
+
+	receipt := validReceipt()
+	receipt.Retailer = "Walgreens"
+	receipt.Timezone = "Mars/Olympus"
+	if _, err := timezones.PurchasedAt(receipt); err == nil {
+		t.Error("PurchasedAt() accepted an unknown timezone")
+	}
+}
+
+// The store's zone can move a purchase to another day or into or out of the afternoon window
+func TestPointsFollowTheStoreClock(t *testing.T) {
+	t.Cleanup(func() { SetActiveTimezones(Timezones{Default: time.UTC}) })
+	rules := DefaultRuleset
+	tests := []struct {
+		name       string
+		date, time string // Written in UTC
+		storeZone  string
+		wantChange int // Points on the store's clock minus points on the UTC clock
+	}{
+		// 23:30 on the 1st in UTC is 08:30 on the 2nd in Tokyo, which is no longer an odd day
+		{"odd day lost", "2022-01-01", "23:30", "Asia/Tokyo", -rules.OddDayPoints},
+		// 03:00 on the 2nd in UTC is still the 1st in Chicago
+		{"odd day gained", "2022-01-02", "03:00", "America/Chicago", rules.OddDayPoints},
+		// 20:30 in UTC is 15:30 in New York, inside the window
+		{"afternoon gained", "2022-01-02", "20:30", "-05:00", rules.AfternoonPoints},
+		// 14:30 in UTC is 20:00 in India, outside it
+		{"afternoon lost", "2022-01-02", "14:30", "+05:30", -rules.AfternoonPoints},
+		{"same clock", "2022-01-02", "14:30", "Z", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receipt := validReceipt()
+			receipt.PurchaseDate = test.date
+			receipt.PurchaseTime = test.time
+			receipt.Timezone = "UTC"
+
+			SetActiveTimezones(Timezones{Default: time.UTC})
+			inUTC, err := CalculatePoints(rules, receipt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetActiveTimezones(Timezones{Default: time.UTC, Retailers: map[string]*time.Location{"target": mustParseTimezone(t, test.storeZone)}})
+			inStore, err := CalculatePoints(rules, receipt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inStore-inUTC != test.wantChange {
+				t.Errorf("%d points on the store's clock and %d on UTC, want a change of %d", inStore, inUTC, test.wantChange)
+			}
+		})
+	}
+}
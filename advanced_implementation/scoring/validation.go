@@ -0,0 +1,101 @@
+package scoring
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Why ValidateReceiptFields turned a receipt down. Also the reason label of receipts_rejected_total
+const (
+	MissingField = "missing_field"
+	BadDate      = "bad_date"
+	BadTime      = "bad_time"
+	BadAmount    = "bad_amount"
+	BadTimezone  = "bad_timezone"
+)
+
+// InvalidReceiptError is what Score returns for a receipt that fails ValidateReceiptFields
+type InvalidReceiptError struct {
+	Reason string
+}
+
+func (e *InvalidReceiptError) Error() string {
+	return "the receipt is invalid: " + e.Reason
+}
+
+func isValidDate(dateStr string) bool {
+	_, err := parsePurchaseDate(dateStr)
+	return err == nil
+}
+
+func isValidTime(timeStr string) bool {
+	_, err := parsePurchaseTime(timeStr)
+	return err == nil
+}
+
+// isValidNumber accepts plain amounts such as 12, 12.5 or 12.50. ParseFloat alone also lets through NaN, Inf, 1e9 and negatives
+func isValidNumber(numStr string) bool {
+	whole, cents, hasCents := strings.Cut(numStr, ".")
+	if !allDigits(whole) {
+		return false
+	}
+	return !hasCents || (len(cents) <= 2 && allDigits(cents))
+}
+
+func allDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// ValidateReceiptFields checks that every field of the receipt is present and has the expected format. It returns why the
+// receipt is invalid, one of the reasons above, or "" if it is valid
+func ValidateReceiptFields(receipt Receipt) string {
+	// Check for empty fields
+	if receipt.Retailer == "" || receipt.PurchaseDate == "" || receipt.PurchaseTime == "" ||
+		len(receipt.Items) == 0 || receipt.Total == "" {
+		return MissingField
+	}
+
+	// Check type
+	if reflect.TypeOf(receipt.Retailer).Kind() != reflect.String ||
+		reflect.TypeOf(receipt.PurchaseDate).Kind() != reflect.String ||
+		reflect.TypeOf(receipt.PurchaseTime).Kind() != reflect.String ||
+		reflect.TypeOf(receipt.Total).Kind() != reflect.String {
+		return MissingField
+	}
+	// Check if valid date, time and total
+	if !isValidDate(receipt.PurchaseDate) {
+		return BadDate
+	}
+	if !isValidTime(receipt.PurchaseTime) {
+		return BadTime
+	}
+	if !isValidNumber(receipt.Total) {
+		return BadAmount
+	}
+	if receipt.Timezone != "" {
+		if _, err := ParseTimezone(receipt.Timezone); err != nil {
+			return BadTimezone
+		}
+	}
+
+	// Validate each item and check if price is number and description is string
+	for _, item := range receipt.Items {
+		if item.ShortDescription == "" || item.Price == "" {
+			return MissingField
+		}
+		if !isValidNumber(item.Price) {
+			return BadAmount
+		}
+		if reflect.TypeOf(item.ShortDescription).Kind() != reflect.String ||
+			reflect.TypeOf(item.Price).Kind() != reflect.String {
+			return MissingField
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,56 @@
+package scoring
+
+import (
+	"errors"
+	"testing"
+)
+
+func validReceipt() Receipt {
+	return Receipt{
+		Id:           "abc",
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	}
+}
+
+func TestValidateReceiptFieldsReasons(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Receipt)
+		want   string
+	}{
+		{"valid", func(r *Receipt) {}, ""},
+		{"valid with timezone", func(r *Receipt) { r.Timezone = "America/Chicago" }, ""},
+		{"no retailer", func(r *Receipt) { r.Retailer = "" }, MissingField},
+		{"no items", func(r *Receipt) { r.Items = nil }, MissingField},
+		{"item without description", func(r *Receipt) { r.Items[0].ShortDescription = "" }, MissingField},
+		{"item without price", func(r *Receipt) { r.Items[0].Price = "" }, MissingField},
+		{"bad date", func(r *Receipt) { r.PurchaseDate = "2022-13-01" }, BadDate},
+		{"bad time", func(r *Receipt) { r.PurchaseTime = "25:00" }, BadTime},
+		{"bad total", func(r *Receipt) { r.Total = "1e9" }, BadAmount},
+		{"bad price", func(r *Receipt) { r.Items[0].Price = "-1.00" }, BadAmount},
+		{"bad timezone", func(r *Receipt) { r.Timezone = "Mars/Olympus" }, BadTimezone},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receipt := validReceipt()
+			test.change(&receipt)
+			if got := ValidateReceiptFields(receipt); got != test.want {
+				t.Errorf("ValidateReceiptFields() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScoreReturnsTheReason(t *testing.T) {
+	receipt := validReceipt()
+	receipt.PurchaseTime = "12:00XM"
+	_, err := Score(DefaultRuleset, receipt)
+	var invalid *InvalidReceiptError
+	if !errors.As(err, &invalid) || invalid.Reason != BadTime {
+		t.Fatalf("Score() error = %v, want an InvalidReceiptError for %s", err, BadTime)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"restGo/config"
+	"restGo/messaging"
+)
+
+// Body of the replay and purge requests. Either list the Ids or set all, so nothing is replayed or purged by accident
+type deadLetterSelection struct {
+	Ids []string `json:"ids"`
+	All bool     `json:"all"`
+}
+
+func (s deadLetterSelection) validate() error {
+	if s.All == (len(s.Ids) > 0) {
+		return errors.New("provide either a list of ids or all, but not both")
+	}
+	return nil
+}
+
+// GET request handler that lists the receipts parked in the dead letter queue
+func listDeadLetters(conn *messaging.Connection) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		deadLetters, err := messaging.ListDeadLetters(context.Request.Context(), conn.Current())
+		if err != nil {
+			context.IndentedJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		context.IndentedJSON(http.StatusOK, gin.H{"count": len(deadLetters), "deadLetters": deadLetters})
+	}
+}
+
+// POST request handler that moves the selected dead letters back to the POST_receipts queue
+func replayDeadLetters(conn *messaging.Connection) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		var selection deadLetterSelection
+		if err := context.ShouldBindJSON(&selection); err != nil || selection.validate() != nil {
+			context.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Send {\"ids\": [...]} or {\"all\": true}"})
+			return
+		}
+		replayed, err := messaging.ReplayDeadLetters(context.Request.Context(), conn.Current(), selection.Ids)
+		if err != nil {
+			context.IndentedJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "replayed": replayed})
+			return
+		}
+		context.IndentedJSON(http.StatusOK, gin.H{"replayed": replayed})
+	}
+}
+
+// POST request handler that deletes the selected dead letters
+func purgeDeadLetters(conn *messaging.Connection) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		var selection deadLetterSelection
+		if err := context.ShouldBindJSON(&selection); err != nil || selection.validate() != nil {
+			context.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Send {\"ids\": [...]} or {\"all\": true}"})
+			return
+		}
+		purged, err := messaging.PurgeDeadLetters(context.Request.Context(), conn.Current(), selection.Ids)
+		if err != nil {
+			context.IndentedJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "purged": purged})
+			return
+		}
+		context.IndentedJSON(http.StatusOK, gin.H{"purged": purged})
+	}
+}
+
+const deadLetterUsage = `Usage: server dlq <command> [--all | Id...]
+
+Commands:
+  list            Print every receipt in the dead letter queue with the reason it failed
+  replay          Move receipts back to the POST_receipts queue
+  purge           Delete receipts from the dead letter queue`
+
+// runDeadLetterCommand handles "server dlq ..." so an operator can recover parked receipts without the HTTP server running
+func runDeadLetterCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(deadLetterUsage)
+		return 2
+	}
+	flags := flag.NewFlagSet("dlq "+args[0], flag.ContinueOnError)
+	all := flags.Bool("all", false, "apply to every receipt in the dead letter queue")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	selection := deadLetterSelection{Ids: flags.Args(), All: *all}
+
+	// Settings come from the config file and the environment, the flags here are the command's own
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		fmt.Println("Invalid configuration:", err)
+		return 2
+	}
+	messaging.UseQueueNames(cfg.Bus())
+	conn, err := amqp.Dial(cfg.RabbitMQURL)
+	if err != nil {
+		fmt.Println("Failed to connect to RabbitMQ:", err)
+		return 1
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "list":
+		deadLetters, err := messaging.ListDeadLetters(ctx, conn)
+		if err != nil {
+			fmt.Println("Failed to list the dead letter queue:", err)
+			return 1
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(deadLetters)
+	case "replay":
+		if err := selection.validate(); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		replayed, err := messaging.ReplayDeadLetters(ctx, conn, selection.Ids)
+		fmt.Println("Replayed", replayed, "receipts")
+		if err != nil {
+			fmt.Println("Replay stopped early:", err)
+			return 1
+		}
+	case "purge":
+		if err := selection.validate(); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		purged, err := messaging.PurgeDeadLetters(ctx, conn, selection.Ids)
+		fmt.Println("Purged", purged, "receipts")
+		if err != nil {
+			fmt.Println("Purge stopped early:", err)
+			return 1
+		}
+	default:
+		fmt.Println(deadLetterUsage)
+		return 2
+	}
+	return 0
+}
+
+// Body of a request to create an API key
+type newAPIKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// GET request handler that lists the API keys, without their secrets
+func listAPIKeys(keys *apiKeyStore) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		list := keys.List()
+		context.IndentedJSON(http.StatusOK, gin.H{"count": len(list), "apiKeys": list})
+	}
+}
+
+// POST request handler that creates an API key. The key is only ever shown in this response
+func createAPIKey(keys *apiKeyStore) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		var request newAPIKey
+		if err := context.ShouldBindJSON(&request); err != nil || request.Name == "" {
+			context.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Send {\"name\": \"...\", \"scopes\": [...]}"})
+			return
+		}
+		key, secret, err := keys.Create(request.Name, request.Scopes)
+		if err != nil {
+			context.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		key.Hash = ""
+		context.IndentedJSON(http.StatusCreated, gin.H{"apiKey": key, "key": secret})
+	}
+}
+
+// POST request handler that gives an API key a new secret
+func rotateAPIKey(keys *apiKeyStore) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		key, secret, err := keys.Rotate(context.Param("Id"))
+		if err != nil {
+			context.IndentedJSON(apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		key.Hash = ""
+		context.IndentedJSON(http.StatusOK, gin.H{"apiKey": key, "key": secret})
+	}
+}
+
+// POST request handler that revokes an API key
+func revokeAPIKey(keys *apiKeyStore) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		key, err := keys.Revoke(context.Param("Id"))
+		if err != nil {
+			context.IndentedJSON(apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		key.Hash = ""
+		context.IndentedJSON(http.StatusOK, gin.H{"apiKey": key})
+	}
+}
+
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errUnknownKey):
+		return http.StatusNotFound
+	case errors.Is(err, errKeyRevoked):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+const apiKeyUsage = `Usage: server apikey <command>
+
+Commands:
+  create --name <name> --scopes <scope,...>   Create a key and print it. Scopes are receipts:read, receipts:write and admin
+  rotate <Id>                                 Give a key a new secret and print it. The old one stops working
+  revoke <Id>                                 Stop a key from working for good
+  list                                        Print every key, without the secrets
+
+The key store can only be opened while the server is stopped. While it runs, use the /admin/apikeys endpoints instead`
+
+// runAPIKeyCommand handles "server apikey ...", which is how the first admin key is made
+func runAPIKeyCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(apiKeyUsage)
+		return 2
+	}
+	flags := flag.NewFlagSet("apikey "+args[0], flag.ContinueOnError)
+	name := flags.String("name", "", "who the key is for")
+	scopes := flags.String("scopes", "", "comma separated scopes of the key")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	// Settings come from the config file and the environment, the flags here are the command's own
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		fmt.Println("Invalid configuration:", err)
+		return 2
+	}
+	slog.SetLogLoggerLevel(slog.LevelWarn) // Badger's info lines would bury the output
+	keys, err := openAPIKeys(cfg.AuthDir)
+	if err != nil {
+		fmt.Println("Failed to open the API key store. If the server is running, use the /admin/apikeys endpoints:", err)
+		return 1
+	}
+	defer keys.Close()
+
+	printKey := func(key APIKey, secret string) {
+		fmt.Printf("Id:     %s\nName:   %s\nScopes: %s\nKey:    %s\n\n", key.Id, key.Name, strings.Join(key.Scopes, ","), secret)
+		fmt.Println("Keep the key somewhere safe, it can't be shown again")
+	}
+	switch {
+	case args[0] == "create" && *name != "":
+		var scopeList []string
+		for _, scope := range strings.Split(*scopes, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopeList = append(scopeList, scope)
+			}
+		}
+		key, secret, err := keys.Create(*name, scopeList)
+		if err != nil {
+			fmt.Println("Failed to create the key:", err)
+			return 1
+		}
+		printKey(key, secret)
+	case args[0] == "rotate" && flags.NArg() == 1:
+		key, secret, err := keys.Rotate(flags.Arg(0))
+		if err != nil {
+			fmt.Println("Failed to rotate the key:", err)
+			return 1
+		}
+		printKey(key, secret)
+	case args[0] == "revoke" && flags.NArg() == 1:
+		if _, err := keys.Revoke(flags.Arg(0)); err != nil {
+			fmt.Println("Failed to revoke the key:", err)
+			return 1
+		}
+		fmt.Println("Revoked", flags.Arg(0))
+	case args[0] == "list":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(keys.List())
+	default:
+		fmt.Println(apiKeyUsage)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"restGo/logging"
+)
+
+// Scopes an API key or token can be given
+const (
+	readScope  = "receipts:read"  // GET /receipts/:Id/points
+	writeScope = "receipts:write" // POST /receipts/process
+	adminScope = "admin"          // Everything under /admin
+)
+
+var allScopes = []string{readScope, writeScope, adminScope}
+
+// An API key looks like rk_<Id>_<secret>. The Id finds the key and the secret proves it. Only a SHA-256 hash of the secret is
+// stored: the secret is 32 random bytes, so a slow hash like bcrypt would add nothing but time to every request
+const apiKeyPrefix = "rk_"
+
+var (
+	errUnknownKey = errors.New("no API key with that Id")
+	errKeyRevoked = errors.New("the API key is revoked")
+)
+
+// APIKey is what the store keeps about a key. The secret itself is only shown once, when the key is created or rotated
+type APIKey struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"` // Who the key is for, logged with every request made with it
+	Scopes    []string   `json:"scopes"`
+	Hash      string     `json:"hash,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RotatedAt *time.Time `json:"rotatedAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"` // Revoked keys are kept so the list shows who had access
+}
+
+func (k APIKey) hasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// apiKeyStore keeps the keys in a BadgerDB of their own, owned by the server like the outbox, and in memory so checking a key
+// doesn't touch the disk
+type apiKeyStore struct {
+	db   *badger.DB
+	mu   sync.RWMutex
+	keys map[string]APIKey
+}
+
+func openAPIKeys(path string) (*apiKeyStore, error) {
+	db, err := badger.Open(badger.DefaultOptions(path).WithLogger(logging.Library("badger")))
+	if err != nil {
+		return nil, err
+	}
+	store := &apiKeyStore{db: db, keys: map[string]APIKey{}}
+	err = db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(v []byte) error {
+				var key APIKey
+				if err := json.Unmarshal(v, &key); err != nil {
+					return err
+				}
+				store.keys[key.Id] = key
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *apiKeyStore) Close() error {
+	return s.db.Close()
+}
+
+// save writes the key to disk before anyone can use the change
+func (s *apiKeyStore) save(key APIKey) error {
+	body, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key.Id), body)
+	})
+	if err != nil {
+		return err
+	}
+	s.keys[key.Id] = key
+	return nil
+}
+
+// Create makes a key and returns it with the full key to hand to the client
+func (s *apiKeyStore) Create(name string, scopes []string) (APIKey, string, error) {
+	if err := validScopes(scopes); err != nil {
+		return APIKey{}, "", err
+	}
+	secret, hash, err := newSecret()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	key := APIKey{Id: generateId(12), Name: name, Scopes: scopes, Hash: hash, CreatedAt: time.Now().UTC()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(key); err != nil {
+		return APIKey{}, "", err
+	}
+	return key, formatAPIKey(key.Id, secret), nil
+}
+
+// Rotate gives a key a new secret. The old one stops working straight away
+func (s *apiKeyStore) Rotate(Id string) (APIKey, string, error) {
+	secret, hash, err := newSecret()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[Id]
+	switch {
+	case !ok:
+		return APIKey{}, "", errUnknownKey
+	case key.RevokedAt != nil:
+		return APIKey{}, "", errKeyRevoked
+	}
+	now := time.Now().UTC()
+	key.Hash, key.RotatedAt = hash, &now
+	if err := s.save(key); err != nil {
+		return APIKey{}, "", err
+	}
+	return key, formatAPIKey(key.Id, secret), nil
+}
+
+// Revoke stops a key from working for good
+func (s *apiKeyStore) Revoke(Id string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[Id]
+	if !ok {
+		return APIKey{}, errUnknownKey
+	}
+	if key.RevokedAt != nil {
+		return key, nil
+	}
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	return key, s.save(key)
+}
+
+// List returns every key, revoked ones included, oldest first and without the hashes
+func (s *apiKeyStore) List() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		key.Hash = ""
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b APIKey) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return keys
+}
+
+// Authenticate returns the key a client presented, if it is one of ours and not revoked
+func (s *apiKeyStore) Authenticate(presented string) (APIKey, bool) {
+	Id, secret, ok := parseAPIKey(presented)
+	if !ok {
+		return APIKey{}, false
+	}
+	s.mu.RLock()
+	key, ok := s.keys[Id]
+	s.mu.RUnlock()
+	if !ok || key.RevokedAt != nil {
+		return APIKey{}, false
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.Hash)) != 1 {
+		return APIKey{}, false
+	}
+	return key, true
+}
+
+func newSecret() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+	return secret, hashSecret(secret), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func formatAPIKey(Id, secret string) string {
+	return apiKeyPrefix + Id + "_" + secret
+}
+
+// parseAPIKey splits a key into its Id and secret. Ids are letters and digits, so the first _ after the prefix ends it
+func parseAPIKey(presented string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(presented, apiKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+	Id, secret, ok := strings.Cut(rest, "_")
+	return Id, secret, ok && Id != "" && secret != ""
+}
+
+func validScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("give the key at least one scope of %s", strings.Join(allScopes, ", "))
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(allScopes, scope) {
+			return fmt.Errorf("unknown scope %q, use %s", scope, strings.Join(allScopes, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	principalKey = "principal" // Where authenticate leaves the caller in the gin context
+)
+
+// principal is who made a request, as proven by an API key or a JWT
+type principal struct {
+	Name   string
+	Scopes []string
+}
+
+// authenticator checks API keys and, if a secret is set, HS256 JWT bearer tokens. When auth isn't required requests without
+// credentials are let through, but credentials that are sent still have to be valid, so a broken client is noticed before
+// auth is turned on
+type authenticator struct {
+	required    bool
+	keys        *apiKeyStore
+	jwtSecret   []byte
+	jwtIssuer   string
+	jwtAudience string
+}
+
+// authenticate finds out who is calling, from X-API-Key or Authorization: Bearer
+func (a *authenticator) authenticate() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		token := context.GetHeader(apiKeyHeader)
+		if bearer, ok := strings.CutPrefix(context.GetHeader("Authorization"), "Bearer "); ok && token == "" {
+			token = strings.TrimSpace(bearer)
+		}
+		if token == "" {
+			if a.required {
+				unauthorized(context, "An API key is required")
+				return
+			}
+			context.Next()
+			return
+		}
+		caller, err := a.verify(token)
+		if err != nil {
+			slog.InfoContext(context.Request.Context(), "Rejected credentials", "reason", err.Error())
+			unauthorized(context, "The API key or token is invalid")
+			return
+		}
+		context.Set(principalKey, caller)
+		context.Next()
+	}
+}
+
+func (a *authenticator) verify(token string) (principal, error) {
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		key, ok := a.keys.Authenticate(token)
+		if !ok {
+			return principal{}, errors.New("unknown or revoked API key")
+		}
+		return principal{Name: key.Name, Scopes: key.Scopes}, nil
+	}
+	if len(a.jwtSecret) == 0 {
+		return principal{}, errors.New("not an API key and JWTs are not accepted")
+	}
+	return a.verifyJWT(token)
+}
+
+// verifyJWT accepts tokens signed with the shared secret that haven't expired. Scopes are read from the OAuth style "scope"
+// claim, a space separated string, or from a "scopes" list
+func (a *authenticator) verifyJWT(token string) (principal, error) {
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}), jwt.WithExpirationRequired()}
+	if a.jwtIssuer != "" {
+		options = append(options, jwt.WithIssuer(a.jwtIssuer))
+	}
+	if a.jwtAudience != "" {
+		options = append(options, jwt.WithAudience(a.jwtAudience))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) { return a.jwtSecret, nil }, options...)
+	if err != nil {
+		return principal{}, err
+	}
+	caller := principal{}
+	caller.Name, _ = claims.GetSubject()
+	if scope, ok := claims["scope"].(string); ok {
+		caller.Scopes = strings.Fields(scope)
+	}
+	if scopes, ok := claims["scopes"].([]interface{}); ok {
+		for _, scope := range scopes {
+			if scope, ok := scope.(string); ok {
+				caller.Scopes = append(caller.Scopes, scope)
+			}
+		}
+	}
+	return caller, nil
+}
+
+// require lets a request through only if the caller has the scope. It does nothing when auth isn't required
+func (a *authenticator) require(scope string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if !a.required {
+			context.Next()
+			return
+		}
+		checkScope(context, scope)
+	}
+}
+
+// adminOnly guards /admin and /debug/vars, which hand out access and delete receipts. Unlike require it applies whether or not
+// auth is required, so with auth off they still need a key with the admin scope, made with "server apikey create"
+func (a *authenticator) adminOnly() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		checkScope(context, adminScope)
+	}
+}
+
+func checkScope(context *gin.Context, scope string) {
+	caller, ok := context.Get(principalKey)
+	if !ok {
+		unauthorized(context, "An API key with the "+scope+" scope is required")
+		return
+	}
+	if !slices.Contains(caller.(principal).Scopes, scope) {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "The API key does not have the " + scope + " scope"})
+		return
+	}
+	context.Next()
+}
+
+func unauthorized(context *gin.Context, message string) {
+	context.Header("WWW-Authenticate", `Bearer realm="receipts"`)
+	context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+}
+
+// callerName is the name of the key or the subject of the token behind a request, for the access log
+func callerName(context *gin.Context) string {
+	if caller, ok := context.Get(principalKey); ok {
+		return caller.(principal).Name
+	}
+	return ""
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testJWTSecret = []byte("test-secret")
+
+func openTestKeys(t *testing.T, dir string) *apiKeyStore {
+	t.Helper()
+	keys, err := openAPIKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { keys.Close() })
+	return keys
+}
+
+func signJWT(t *testing.T, method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerifyJWT(t *testing.T) {
+	a := &authenticator{jwtSecret: testJWTSecret, jwtIssuer: "issuer", jwtAudience: "receipts"}
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := func(extra jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{"sub": "pos", "iss": "issuer", "aud": "receipts", "exp": exp}
+		for k, v := range extra {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+	tests := []struct {
+		name       string
+		token      string
+		wantScopes []string // nil means the token is refused
+	}{
+		{"scope string", signJWT(t, jwt.SigningMethodHS256, testJWTSecret, valid(jwt.MapClaims{"scope": "receipts:read receipts:write"})), []string{readScope, writeScope}},
+		{"scopes list", signJWT(t, jwt.SigningMethodHS256, testJWTSecret, valid(jwt.MapClaims{"scopes": []string{adminScope}})), []string{adminScope}},
+		{"HS512", signJWT(t, jwt.SigningMethodHS512, testJWTSecret, valid(jwt.MapClaims{"scope": readScope})), []string{readScope}},
+		{"no scopes", signJWT(t, jwt.SigningMethodHS256, testJWTSecret, valid(nil)), []string{}},
+		{"wrong signature", signJWT(t, jwt.SigningMethodHS256, []byte("another-secret"), valid(jwt.MapClaims{"scope": readScope})), nil},
+		{"alg none", signJWT(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid(jwt.MapClaims{"scope": readScope})), nil},
+		{"missing exp", signJWT(t, jwt.SigningMethodHS256, testJWTSecret, valid(jwt.MapClaims{"exp": nil})), nil},
+		{"expired", signJWT(t, jwt.SigningMethodHS256, testJWTSecret, valid(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})), nil},
+		{"wrong issuer", signJWT(t, jwt.SigningMethodHS256, testJWTSecret, valid(jwt.MapClaims{"iss": "someone-else"})), nil},
+		{"missing issuer", signJWT(t, jwt.SigningMethodHS256, testJWTSecret, valid(jwt.MapClaims{"iss": nil})), nil},
+		{"wrong audience", signJWT(t, jwt.SigningMethodHS256, testJWTSecret, valid(jwt.MapClaims{"aud": "billing"})), nil},
+		{"not a JWT", "hello", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			caller, err := a.verify(test.token)
+			if test.wantScopes == nil {
+				if err == nil {
+					t.Errorf("verify() accepted the token as %+v", caller)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verify() = %v", err)
+			}
+			if caller.Name != "pos" || !slices.Equal(caller.Scopes, test.wantScopes) {
+				t.Errorf("verify() = %+v, want pos with %v", caller, test.wantScopes)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTWithoutSecret(t *testing.T) {
+	a := &authenticator{keys: openTestKeys(t, t.TempDir())}
+	token := signJWT(t, jwt.SigningMethodHS256, testJWTSecret, jwt.MapClaims{"sub": "pos", "exp": time.Now().Add(time.Hour).Unix()})
+	if _, err := a.verify(token); err == nil {
+		t.Error("verify() accepted a JWT without a secret configured")
+	}
+}
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		key        string
+		wantId     string
+		wantSecret string
+		wantOk     bool
+	}{
+		{"rk_abc123_s3cr3t", "abc123", "s3cr3t", true},
+		{"rk_abc123_s3c_r3t", "abc123", "s3c_r3t", true}, // The secret can contain _
+		{"abc123_s3cr3t", "", "", false},
+		{"rk_abc123", "", "", false},
+		{"rk__s3cr3t", "", "", false},
+		{"rk_abc123_", "", "", false},
+		{"RK_abc123_s3cr3t", "", "", false},
+		{"", "", "", false},
+	}
+	for _, test := range tests {
+		Id, secret, ok := parseAPIKey(test.key)
+		if ok != test.wantOk || ok && (Id != test.wantId || secret != test.wantSecret) {
+			t.Errorf("parseAPIKey(%q) = %q, %q, %v, want %q, %q, %v", test.key, Id, secret, ok, test.wantId, test.wantSecret, test.wantOk)
+		}
+	}
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := openAPIKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keys.Create("pos", []string{"receipts:delete"}); err == nil {
+		t.Error("created a key with an unknown scope")
+	}
+	key, secret, err := keys.Create("pos", []string{writeScope})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, ok := keys.Authenticate(secret); !ok || found.Id != key.Id || !found.hasScope(writeScope) {
+		t.Fatalf("Authenticate() = %+v, %v for a new key", found, ok)
+	}
+	Id, _, _ := parseAPIKey(secret)
+	if _, ok := keys.Authenticate(formatAPIKey(Id, "guessed")); ok {
+		t.Error("accepted the key's Id with the wrong secret")
+	}
+	if stored := keys.keys[key.Id]; stored.Hash == "" || stored.Hash == secret {
+		t.Errorf("stored %q, want a hash of the secret", stored.Hash)
+	}
+
+	_, rotated, err := keys.Rotate(key.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys.Authenticate(secret); ok {
+		t.Error("the old secret still works after a rotate")
+	}
+	if _, ok := keys.Authenticate(rotated); !ok {
+		t.Error("the new secret doesn't work after a rotate")
+	}
+
+	// Changes are on disk, so they survive a restart
+	keys.Close()
+	keys = openTestKeys(t, dir)
+	if _, ok := keys.Authenticate(rotated); !ok {
+		t.Fatal("the rotated secret doesn't work after reopening the store")
+	}
+	if _, err := keys.Revoke(key.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys.Authenticate(rotated); ok {
+		t.Error("a revoked key still works")
+	}
+	if _, _, err := keys.Rotate(key.Id); !errors.Is(err, errKeyRevoked) {
+		t.Errorf("Rotate() = %v for a revoked key, want %v", err, errKeyRevoked)
+	}
+	if _, err := keys.Revoke("missing"); !errors.Is(err, errUnknownKey) {
+		t.Errorf("Revoke() = %v for an unknown key, want %v", err, errUnknownKey)
+	}
+	if list := keys.List(); len(list) != 1 || list[0].RevokedAt == nil || list[0].Hash != "" {
+		t.Errorf("List() = %+v, want the revoked key without its hash", list)
+	}
+}
+
+// The routes as main sets them up, with the handlers for receipts swapped for ones that just answer 200
+func authRouter(auth *authenticator) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	ok := func(context *gin.Context) { context.Status(http.StatusOK) }
+	router := gin.New()
+	router.Use(auth.authenticate())
+	router.GET("/receipts/:Id/points", auth.require(readScope), ok)
+	router.POST("/receipts/process", auth.require(writeScope), ok)
+	router.GET("/debug/vars", auth.adminOnly(), ok)
+	admin := router.Group("/admin", auth.adminOnly())
+	admin.GET("/apikeys", listAPIKeys(auth.keys))
+	return router
+}
+
+func TestAuthRoutes(t *testing.T) {
+	keys := openTestKeys(t, t.TempDir())
+	_, reader, _ := keys.Create("reader", []string{readScope})
+	_, admin, _ := keys.Create("ops", []string{adminScope})
+	revokedKey, revoked, _ := keys.Create("old", []string{readScope, writeScope, adminScope})
+	keys.Revoke(revokedKey.Id)
+	adminJWT := signJWT(t, jwt.SigningMethodHS256, testJWTSecret, jwt.MapClaims{"sub": "ops", "scope": adminScope, "exp": time.Now().Add(time.Hour).Unix()})
+
+	tests := []struct {
+		name     string
+		required bool
+		method   string
+		path     string
+		header   string
+		key      string
+		want     int
+	}{
+		{"no key", true, http.MethodGet, "/receipts/a/points", "", "", http.StatusUnauthorized},
+		{"read key reads", true, http.MethodGet, "/receipts/a/points", apiKeyHeader, reader, http.StatusOK},
+		{"read key writes", true, http.MethodPost, "/receipts/process", apiKeyHeader, reader, http.StatusForbidden},
+		{"read key as bearer", true, http.MethodGet, "/receipts/a/points", "Authorization", "Bearer " + reader, http.StatusOK},
+		{"malformed key", true, http.MethodGet, "/receipts/a/points", apiKeyHeader, "rk_nosecret", http.StatusUnauthorized},
+		{"revoked key", true, http.MethodGet, "/receipts/a/points", apiKeyHeader, revoked, http.StatusUnauthorized},
+		{"admin key on admin", true, http.MethodGet, "/admin/apikeys", apiKeyHeader, admin, http.StatusOK},
+		{"admin JWT on admin", true, http.MethodGet, "/admin/apikeys", "Authorization", "Bearer " + adminJWT, http.StatusOK},
+		{"read key on admin", true, http.MethodGet, "/admin/apikeys", apiKeyHeader, reader, http.StatusForbidden},
+
+		// Without REQUIRE_AUTH the receipts are open, but keys that are sent must still be valid and admin still needs a key
+		{"open without key", false, http.MethodPost, "/receipts/process", "", "", http.StatusOK},
+		{"open with revoked key", false, http.MethodPost, "/receipts/process", apiKeyHeader, revoked, http.StatusUnauthorized},
+		{"open admin without key", false, http.MethodGet, "/admin/apikeys", "", "", http.StatusUnauthorized},
+		{"open debug vars without key", false, http.MethodGet, "/debug/vars", "", "", http.StatusUnauthorized},
+		{"open admin with read key", false, http.MethodGet, "/admin/apikeys", apiKeyHeader, reader, http.StatusForbidden},
+		{"open admin with admin key", false, http.MethodGet, "/admin/apikeys", apiKeyHeader, admin, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := authRouter(&authenticator{required: test.required, keys: keys, jwtSecret: testJWTSecret})
+			request := httptest.NewRequest(test.method, test.path, nil)
+			if test.header != "" {
+				request.Header.Set(test.header, test.key)
+			}
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			if response.Code != test.want {
+				t.Errorf("%s %s = %d %s, want %d", test.method, test.path, response.Code, response.Body, test.want)
+			}
+			if response.Code == http.StatusUnauthorized && response.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+		})
+	}
+}
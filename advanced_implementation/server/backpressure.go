@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"restGo/alerting"
+	"restGo/config"
+	"restGo/logging"
+	"restGo/messaging"
+)
+
+// What the server does with a new receipt while the consumer is too far behind
+const (
+	rejectOnPressure   = config.RejectOnPressure   // 503 with Retry-After
+	throttleOnPressure = config.ThrottleOnPressure // 429 with Retry-After
+	outboxOnPressure   = config.OutboxOnPressure   // Accept it into the outbox and hold off publishing until the backlog clears
+)
+
+// Exposed at /debug/vars
+var (
+	queueDepthMetric  = expvar.NewInt("receipts_queue_depth")
+	overloadedMetric  = expvar.NewInt("receipts_backpressure_active")
+	backlogErrsMetric = expvar.NewInt("receipts_queue_depth_errors")
+)
+
+// backpressure polls the backlog of the message bus and tells the POST handler and the outbox relay when to hold back
+type backpressure struct {
+	reporter  messaging.BacklogReporter
+	threshold int
+	interval  time.Duration
+	mode      string
+
+	overloaded atomic.Bool
+}
+
+// newBackpressure returns nil when the message bus can't report its backlog, and a nil *backpressure is never overloaded
+func newBackpressure(publisher messaging.Publisher, threshold int, interval time.Duration, mode string) *backpressure {
+	reporter, ok := publisher.(messaging.BacklogReporter)
+	if !ok {
+		slog.Warn("The message bus can't report its backlog, so POST requests are never held back")
+		return nil
+	}
+	return &backpressure{reporter: reporter, threshold: threshold, interval: interval, mode: mode}
+}
+
+// Overloaded reports whether new receipts should be held back
+func (b *backpressure) Overloaded() bool {
+	return b != nil && b.overloaded.Load()
+}
+
+// RetryAfter is how long a client should wait before trying again, in seconds
+func (b *backpressure) RetryAfter() string {
+	return fmt.Sprint(int(b.interval.Seconds() + 0.5))
+}
+
+func (b *backpressure) poll() {
+	ctx, cancel := context.WithTimeout(context.Background(), b.interval)
+	defer cancel()
+	backlog, err := b.reporter.Backlog(ctx)
+	if err != nil {
+		backlogErrsMetric.Add(1)
+		slog.Warn("Failed to check the queue depth", logging.Err(err))
+		return // Keep the last known state rather than flap on a failed check
+	}
+	queueDepthMetric.Set(int64(backlog.Depth))
+	queueDepthGauge.Set(float64(backlog.Depth))
+	overloaded := backlog.Depth > b.threshold || backlog.Blocked != ""
+	// Once on, stay on until the backlog drops well below the threshold, so requests don't flip between accepted and rejected
+	if b.Overloaded() && backlog.Depth > b.threshold*9/10 {
+		overloaded = true
+	}
+	switch {
+	case overloaded && !b.Overloaded():
+		// Send an alert to the monitoring system that the queue is getting full
+		alerting.Raise(ctx, alerting.QueueFull, "Receipts queue is getting full. Holding back new receipts", "depth", backlog.Depth, "mode", b.mode)
+		if backlog.Blocked != "" {
+			alerting.Raise(ctx, alerting.QueueFull, "Publishing is blocked", "reason", backlog.Blocked)
+		}
+		b.overloaded.Store(true)
+		overloadedMetric.Set(1)
+		backpressureGauge.Set(1)
+	case !overloaded && b.Overloaded():
+		slog.Info("Receipts queue is back down. Accepting receipts again", "depth", backlog.Depth)
+		b.overloaded.Store(false)
+		overloadedMetric.Set(0)
+		backpressureGauge.Set(0)
+	}
+}
+
+// watch polls until the server exits. The first check runs before it returns so the server never starts blind
+func (b *backpressure) watch() {
+	if b == nil {
+		return
+	}
+	b.poll()
+	go func() {
+		for range time.Tick(b.interval) {
+			b.poll()
+		}
+	}()
+}
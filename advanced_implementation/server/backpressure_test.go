@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"restGo/messaging"
+	"restGo/scoring"
+)
+
+// scriptedBacklog answers every check with the reading the test set last
+type scriptedBacklog struct {
+	backlog messaging.Backlog
+	err     error
+}
+
+func (s *scriptedBacklog) Backlog(ctx context.Context) (messaging.Backlog, error) {
+	return s.backlog, s.err
+}
+
+func TestBackpressureHysteresis(t *testing.T) {
+	reporter := &scriptedBacklog{}
+	pressure := &backpressure{reporter: reporter, threshold: 100, interval: time.Second, mode: rejectOnPressure}
+	readings := []struct {
+		depth   int
+		blocked string
+		err     error
+		want    bool
+	}{
+		{depth: 50, want: false},
+		{depth: 100, want: false}, // At the threshold is fine
+		{depth: 101, want: true},
+		{depth: 95, want: true}, // Stays on until the backlog is below 90% of the threshold
+		{depth: 91, want: true},
+		{depth: 90, want: false},
+		{depth: 100, want: false}, // And only comes back on above the threshold
+		{depth: 150, want: true},
+		{err: errors.New("management API down"), want: true}, // A failed check keeps the last state
+		{depth: 10, want: false},
+		{err: errors.New("management API down"), want: false},
+		{depth: 0, blocked: "low on memory", want: true}, // The broker blocking publishers turns it on however short the queue
+		{depth: 0, want: false},
+	}
+	for i, reading := range readings {
+		reporter.backlog = messaging.Backlog{Depth: reading.depth, Blocked: reading.blocked}
+		reporter.err = reading.err
+		pressure.poll()
+		if got := pressure.Overloaded(); got != reading.want {
+			t.Errorf("reading %d (depth %d, blocked %q, error %v): overloaded = %v, want %v", i, reading.depth, reading.blocked, reading.err, got, reading.want)
+		}
+	}
+}
+
+func TestBackpressureNeedsAReporter(t *testing.T) {
+	pressure := newBackpressure(&stubPublisher{}, 100, time.Second, rejectOnPressure)
+	if pressure != nil || pressure.Overloaded() {
+		t.Errorf("newBackpressure() = %+v for a publisher that can't report its backlog, want nil", pressure)
+	}
+	pressure.watch() // Doesn't start polling
+}
+
+func TestPostUnderBackpressure(t *testing.T) {
+	tests := []struct {
+		mode           string
+		wantStatus     int
+		wantRetryAfter string
+		wantHeld       bool // Accepted into the outbox without being published
+	}{
+		{rejectOnPressure, http.StatusServiceUnavailable, "3", false},
+		{throttleOnPressure, http.StatusTooManyRequests, "3", false},
+		{outboxOnPressure, http.StatusOK, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			outbox, err := openOutbox(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { outbox.Close() })
+			publisher := &stubPublisher{}
+			pressure := &backpressure{reporter: &scriptedBacklog{backlog: messaging.Backlog{Depth: 500}}, threshold: 100, interval: 2500 * time.Millisecond, mode: test.mode}
+			pressure.poll()
+			router := gin.New()
+			router.POST("/receipts/process", addReceipt(publisher, outbox, fastMode, pressure, scoring.DefaultPolicy))
+
+			body, _ := json.Marshal(testReceipt())
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/receipts/process", bytes.NewReader(body)))
+			if response.Code != test.wantStatus {
+				t.Fatalf("POST /receipts/process = %d %s, want %d", response.Code, response.Body, test.wantStatus)
+			}
+			if got := response.Header().Get("Retry-After"); got != test.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, test.wantRetryAfter)
+			}
+			held, _ := outbox.Count()
+			if test.wantHeld != (held == 1) {
+				t.Errorf("%d receipts in the outbox, want held = %v", held, test.wantHeld)
+			}
+			if published := publisher.Published(); len(published) != 0 {
+				t.Errorf("published %v while the consumer is behind", published)
+			}
+		})
+	}
+}
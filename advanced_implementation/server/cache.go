@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+
+	"restGo/logging"
+	"restGo/scoring"
+)
+
+// receiptCache holds every known receipt with its points, so a GET request is just a lookup. Handlers run concurrently,
+// hence the lock
+type receiptCache struct {
+	mu       sync.RWMutex
+	records  map[string]scoring.Record
+	progress scoring.RescoreProgress
+}
+
+func newReceiptCache() *receiptCache {
+	return &receiptCache{records: map[string]scoring.Record{}}
+}
+
+func (c *receiptCache) Put(record scoring.Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.records[record.Id] = record
+}
+
+func (c *receiptCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.records)
+}
+
+// Get returns the record for an Id. A record the background rescore hasn't reached yet is rescored on the spot, so stale
+// points are never served
+func (c *receiptCache) Get(Id string) (scoring.Record, error) {
+	c.mu.RLock()
+	record, ok := c.records[Id]
+	c.mu.RUnlock()
+	if !ok {
+		return scoring.Record{}, errors.New("no receipt found for that id")
+	}
+	rules := scoring.ActiveRuleset()
+	if !record.Stale(rules) {
+		return record, nil
+	}
+	rescored, err := scoring.Score(rules, record.Receipt)
+	if err != nil {
+		return scoring.Record{}, errors.New("the receipt data is corrupted")
+	}
+	c.Put(rescored)
+	return rescored, nil
+}
+
+// rescore recalculates every record scored under a different ruleset. Records that can no longer be scored are dropped
+func (c *receiptCache) rescore(rules scoring.Ruleset) {
+	c.mu.RLock()
+	stale := []scoring.Record{}
+	for _, record := range c.records {
+		if record.Stale(rules) {
+			stale = append(stale, record)
+		}
+	}
+	c.mu.RUnlock()
+
+	c.progress.Start(rules.Version, len(stale))
+	for i, record := range stale {
+		rescored, err := scoring.Score(rules, record.Receipt)
+		c.mu.Lock()
+		if err != nil {
+			slog.Error("Dropping receipt as its data is corrupted", logging.Receipt(record.Id), logging.Err(err))
+			delete(c.records, record.Id)
+			c.progress.Add(0, 1)
+		} else {
+			c.records[record.Id] = rescored
+			c.progress.Add(1, 0)
+		}
+		c.mu.Unlock()
+		if (i+1)%1000 == 0 {
+			slog.Info("Cache rescore", "progress", c.progress.Status())
+		}
+	}
+	c.progress.Finish()
+	if len(stale) > 0 {
+		slog.Info("Cache rescore finished", "progress", c.progress.Status())
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"restGo/scoring"
+)
+
+func cacheReceipt(Id string) scoring.Receipt {
+	return scoring.Receipt{
+		Id:           Id,
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []scoring.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	}
+}
+
+// useNextRuleset bumps the active ruleset for the test, with more points for the retailer name, and returns it
+func useNextRuleset(t *testing.T) scoring.Ruleset {
+	t.Helper()
+	rules := scoring.DefaultRuleset
+	rules.Version++
+	rules.PointsPerRetailerChar++
+	scoring.SetActiveRuleset(rules)
+	t.Cleanup(func() { scoring.SetActiveRuleset(scoring.DefaultRuleset) })
+	return rules
+}
+
+// fillCache returns a cache with a record scored under the default ruleset for each Id, plus "corrupt", which no longer scores
+func fillCache(t *testing.T, Ids ...string) *receiptCache {
+	t.Helper()
+	cache := newReceiptCache()
+	for _, Id := range Ids {
+		record, err := scoring.Score(scoring.DefaultRuleset, cacheReceipt(Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Put(record)
+	}
+	corrupt := scoring.Record{Receipt: cacheReceipt("corrupt"), RulesetVersion: scoring.DefaultRuleset.Version}
+	corrupt.Total = "lots"
+	cache.Put(corrupt)
+	return cache
+}
+
+func TestCacheRescoresOnGet(t *testing.T) {
+	cache := fillCache(t, "a")
+	rules := useNextRuleset(t)
+	want, _ := scoring.Score(rules, cacheReceipt("a"))
+
+	// The background rescore hasn't got to it, but the stale points are never served
+	record, err := cache.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Points != want.Points || record.RulesetVersion != rules.Version {
+		t.Errorf("Get() = %d points under version %d, want %d under version %d", record.Points, record.RulesetVersion, want.Points, rules.Version)
+	}
+	// And the rescored record replaces the stale one
+	cache.mu.RLock()
+	cached := cache.records["a"]
+	cache.mu.RUnlock()
+	if cached.RulesetVersion != rules.Version {
+		t.Errorf("the cache still has version %d", cached.RulesetVersion)
+	}
+
+	if _, err := cache.Get("corrupt"); err == nil {
+		t.Error("Get() served a record that can't be scored")
+	}
+	if _, err := cache.Get("missing"); err == nil {
+		t.Error("Get() found a receipt that was never added")
+	}
+}
+
+func TestCacheRescore(t *testing.T) {
+	cache := fillCache(t, "a", "b", "c")
+	rules := useNextRuleset(t)
+	fresh, _ := scoring.Score(rules, cacheReceipt("d"))
+	cache.Put(fresh)
+
+	cache.rescore(rules)
+	want := scoring.RescoreStatus{RulesetVersion: rules.Version, Total: 4, Rescored: 3, Failed: 1, Finished: true}
+	if got := cache.progress.Status(); got != want {
+		t.Errorf("progress = %+v, want %+v", got, want)
+	}
+	if cache.Len() != 4 {
+		t.Errorf("%d receipts in the cache, want the corrupt one dropped", cache.Len())
+	}
+	for _, Id := range []string{"a", "b", "c", "d"} {
+		cache.mu.RLock()
+		record := cache.records[Id]
+		cache.mu.RUnlock()
+		if record.Points != fresh.Points || record.RulesetVersion != rules.Version {
+			t.Errorf("%s has %d points under version %d, want %d under version %d", Id, record.Points, record.RulesetVersion, fresh.Points, rules.Version)
+		}
+	}
+}
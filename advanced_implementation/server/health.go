@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger"
+	"github.com/gin-gonic/gin"
+	"restGo/health"
+	"restGo/messaging"
+)
+
+// Set once the stored receipts are in the cache. Until then a receipt missing from the cache may just not be loaded yet
+var loaded = health.NewFlag("still loading the stored receipts")
+
+// Set once the server is connected to the message bus and serves every route. The probes answer before that
+var connected = health.NewFlag("still connecting to the message bus")
+
+// probes serves /healthz and /readyz from the start, and every other route once app is set. A broker that is down at startup
+// keeps the server connecting for as long as it takes, and the orchestrator should see a live process that isn't ready yet
+func probes(checker *health.Checker, app *atomic.Pointer[gin.Engine]) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", health.Live())
+	mux.Handle("GET /readyz", checker.Ready())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		router := app.Load()
+		if router == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": "The server is still starting. Please try again shortly"}` + "\n"))
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+// startup fails until the server is connected and has loaded the stored receipts
+func startup(ctx context.Context) (string, error) {
+	if _, err := connected.Check(ctx); err != nil {
+		return "", err
+	}
+	return loaded.Check(ctx)
+}
+
+// addReadiness adds what /readyz checks once everything is open. The broker only has to be up in the modes that need it to
+// accept a receipt, the durable mode writes receipts to the outbox and the relay publishes them once the broker is back
+func addReadiness(checker *health.Checker, db *badger.DB, outbox *Outbox, publisher messaging.Publisher, mode string) {
+	checker.Add("store", true, health.Badger(db))
+	checker.Add("outbox", true, func(ctx context.Context) (string, error) {
+		pending, err := outbox.Count()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d receipts waiting to be published", pending), nil
+	})
+	if reporter, ok := publisher.(messaging.HealthReporter); ok {
+		checker.Add("bus", mode != durableMode, func(ctx context.Context) (string, error) {
+			return "", reporter.Healthy(ctx)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"restGo/health"
+)
+
+// While the server is connecting only the probes answer, and /readyz says why it isn't ready
+func TestProbesAnswerWhileStarting(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	started := health.NewFlag("still connecting to the message bus")
+	checker := &health.Checker{}
+	checker.Add("startup", true, started.Check)
+	var app atomic.Pointer[gin.Engine]
+	handler := probes(checker, &app)
+	get := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		return response
+	}
+
+	if response := get("/healthz"); response.Code != http.StatusOK {
+		t.Errorf("/healthz = %d while starting, want 200", response.Code)
+	}
+	if response := get("/readyz"); response.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d %s while starting, want 503", response.Code, response.Body)
+	}
+	if response := get("/receipts/a/points"); response.Code != http.StatusServiceUnavailable || response.Header().Get("Retry-After") == "" {
+		t.Errorf("GET /receipts/a/points = %d while starting, want 503 with Retry-After", response.Code)
+	}
+
+	router := gin.New()
+	router.GET("/receipts/:Id/points", func(context *gin.Context) { context.Status(http.StatusOK) })
+	app.Store(router)
+	started.Set()
+	if response := get("/readyz"); response.Code != http.StatusOK {
+		t.Errorf("/readyz = %d %s once started, want 200", response.Code, response.Body)
+	}
+	if response := get("/receipts/a/points"); response.Code != http.StatusOK {
+		t.Errorf("GET /receipts/a/points = %d once started, want 200", response.Code)
+	}
+}
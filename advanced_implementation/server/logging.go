@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"restGo/logging"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID keeps whatever a client or proxy sends in X-Request-ID out of the logs unless it looks like an ID
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 64 {
+		return false
+	}
+	for _, c := range requestID {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID gives every request an ID, taken from X-Request-ID if the client sent a sensible one. It is sent back in the response,
+// logged with every line about the request and published with the receipt so the consumer logs it too
+func requestID() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		reqId := context.GetHeader(requestIDHeader)
+		if !validRequestID(reqId) {
+			reqId = generateId(16)
+		}
+		context.Header(requestIDHeader, reqId)
+		context.Request = context.Request.WithContext(logging.WithRequestID(context.Request.Context(), reqId))
+		context.Next()
+	}
+}
+
+// accessLog replaces gin's own request log. It logs the route rather than the path and leaves out the client's address
+func accessLog() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		start := time.Now()
+		context.Next()
+		level := slog.LevelInfo
+		if context.Writer.Status() >= 500 {
+			level = slog.LevelWarn
+		}
+		attrs := []any{
+			"method", context.Request.Method,
+			"route", context.FullPath(),
+			"status", context.Writer.Status(),
+			"duration", time.Since(start),
+		}
+		if client := callerName(context); client != "" {
+			attrs = append(attrs, "client", client) // The name of the API key, never the key
+		}
+		slog.Log(context.Request.Context(), level, "Handled request", attrs...)
+	}
+}
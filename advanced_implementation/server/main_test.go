@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/gin-gonic/gin"
+	"restGo/messaging"
+	"restGo/pipeline"
+	"restGo/scoring"
+)
+
+// testPipeline is the whole way a receipt takes in production, with the broker swapped for an in-process bus: the POST handler
+// publishes to the bus, the consumer's pipeline handles it and stores it in BadgerDB
+type testPipeline struct {
+	router *gin.Engine
+	db     *badger.DB
+	bus    *messaging.InProcessBus
+}
+
+func newTestPipeline(t *testing.T) *testPipeline {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := openOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := messaging.NewInProcessBus(10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan struct{})
+	subscribed := make(chan error, 1)
+	go func() {
+		subscribed <- bus.Subscribe(ctx, pipeline.New(db, 10).Handle, func() { close(ready) })
+	}()
+	select {
+	case <-ready:
+	case err := <-subscribed:
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		<-subscribed
+		bus.Close()
+		outbox.Close()
+		db.Close()
+	})
+
+	router := gin.New()
+	router.Use(traced(), requestID())
+	router.POST("/receipts/process", addReceipt(bus, outbox, confirmMode, nil, scoring.DefaultPolicy))
+	return &testPipeline{router: router, db: db, bus: bus}
+}
+
+// post submits a receipt and returns the Id the server gave it
+func (p *testPipeline) post(t *testing.T, receipt map[string]any, headers map[string]string) string {
+	t.Helper()
+	body, _ := json.Marshal(receipt)
+	request := httptest.NewRequest(http.MethodPost, "/receipts/process", bytes.NewReader(body))
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	response := httptest.NewRecorder()
+	p.router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("POST /receipts/process = %d %s", response.Code, response.Body)
+	}
+	var accepted struct{ Id string }
+	if err := json.Unmarshal(response.Body.Bytes(), &accepted); err != nil || accepted.Id == "" {
+		t.Fatalf("no Id in the response %s", response.Body)
+	}
+	return accepted.Id
+}
+
+// stored waits for the consumer to write the receipt and returns what it stored
+func (p *testPipeline) stored(t *testing.T, Id string) scoring.Record {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var record scoring.Record
+		err := p.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get([]byte(Id))
+			if err != nil {
+				return err
+			}
+			return item.Value(func(v []byte) error {
+				return json.Unmarshal(v, &record)
+			})
+		})
+		if err == nil {
+			return record
+		}
+		if !errors.Is(err, badger.ErrKeyNotFound) || time.Now().After(deadline) {
+			t.Fatalf("receipt %s was not stored: %v", Id, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A receipt from yesterday, so the policy doesn't find it too new or too old
+func testReceipt() map[string]any {
+	return map[string]any{
+		"retailer":     "M&M Corner Market",
+		"purchaseDate": time.Now().AddDate(0, 0, -1).Format("2006-01-02"),
+		"purchaseTime": "14:33",
+		"items": []map[string]string{
+			{"shortDescription": "Gatorade", "price": "2.25"},
+			{"shortDescription": "Gatorade", "price": "2.25"},
+		},
+		"total": "4.50",
+	}
+}
+
+func TestReceiptGoesFromPostToBadger(t *testing.T) {
+	p := newTestPipeline(t)
+	receipt := testReceipt()
+	Id := p.post(t, receipt, nil)
+
+	record := p.stored(t, Id)
+	if record.Id != Id || record.Retailer != receipt["retailer"] || record.Total != "4.50" || len(record.Items) != 2 {
+		t.Errorf("stored %+v, want the submitted receipt", record)
+	}
+	cached, err := receipts.Get(Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Points != cached.Points || record.RulesetVersion != scoring.ActiveRuleset().Version {
+		t.Errorf("stored %d points under version %d, the server answered %d under version %d", record.Points, record.RulesetVersion, cached.Points, scoring.ActiveRuleset().Version)
+	}
+	if dead := p.bus.DeadLetters(); len(dead) != 0 {
+		t.Errorf("receipts were parked: %+v", dead)
+	}
+}
+
+// In the fast and durable modes the client has its Id before the publish is done, even though the handler is still running
+func TestRespondsBeforePublishing(t *testing.T) {
+	for _, mode := range []string{fastMode, durableMode} {
+		t.Run(mode, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			outbox, err := openOutbox(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			publisher := &stubPublisher{hold: make(chan struct{}), started: make(chan string, 1)}
+			router := gin.New()
+			router.POST("/receipts/process", addReceipt(publisher, outbox, mode, nil, scoring.DefaultPolicy))
+			server := httptest.NewServer(router)
+			t.Cleanup(func() {
+				server.Close()
+				outbox.Close()
+			})
+			var release sync.Once
+			t.Cleanup(func() { release.Do(func() { close(publisher.hold) }) }) // Runs first, so a failed test doesn't hang in Close
+
+			body, _ := json.Marshal(testReceipt())
+			client := &http.Client{Timeout: 2 * time.Second}
+			response, err := client.Post(server.URL+"/receipts/process", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("no response while the publish is held: %v", err)
+			}
+			defer response.Body.Close()
+			var accepted struct{ Id string }
+			if err := json.NewDecoder(response.Body).Decode(&accepted); err != nil || response.StatusCode != http.StatusOK {
+				t.Fatalf("POST /receipts/process = %d, %v", response.StatusCode, err)
+			}
+			if Id := <-publisher.started; Id != accepted.Id {
+				t.Fatalf("publishing %s, want %s", Id, accepted.Id)
+			}
+			if published := publisher.Published(); len(published) != 0 {
+				t.Fatalf("published %v before the hold was released", published)
+			}
+
+			release.Do(func() { close(publisher.hold) })
+			eventually(t, "the receipt is published and out of the outbox", func() bool {
+				left, _ := outbox.Count()
+				return len(publisher.Published()) == 1 && left == 0
+			})
+		})
+	}
+}
+
+// eventually fails the test unless ok becomes true within a few seconds
+func eventually(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// A stored record that can't be read is left out, and the rest are still served
+func TestLoadReceiptsSkipsCorruptRecords(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	record, _ := scoring.Score(scoring.DefaultRuleset, cacheReceipt("a"))
+	body, _ := json.Marshal(record)
+	err = db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte("a"), body); err != nil {
+			return err
+		}
+		return txn.Set([]byte("corrupt"), []byte("{not json"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := receipts
+	receipts = newReceiptCache()
+	t.Cleanup(func() { receipts = previous })
+
+	if err := loadReceipts(db); err != nil {
+		t.Fatalf("loadReceipts() = %v", err)
+	}
+	if receipts.Len() != 1 {
+		t.Errorf("%d receipts in the cache, want only a", receipts.Len())
+	}
+	if got, err := receipts.Get("a"); err != nil || got.Points != record.Points {
+		t.Errorf("Get(a) = %+v, %v, want %d points", got, err, record.Points)
+	}
+}
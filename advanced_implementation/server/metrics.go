@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Exposed at /metrics for Prometheus
+var (
+	requestsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route and status",
+	}, []string{"method", "route", "status"})
+	requestDurationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "How long HTTP requests took to answer, by method, route and status",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	rejectedMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "receipts_rejected_total",
+		Help: "Submitted receipts turned away, by reason: malformed, what failed validation or the policy rule they broke",
+	}, []string{"reason"})
+	pointsMetric = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "receipt_points",
+		Help:    "Points awarded to accepted receipts",
+		Buckets: []float64{10, 25, 50, 75, 100, 150, 200, 300, 500},
+	})
+	publishFailuresMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "receipts_publish_failures_total",
+		Help: "Receipts the message bus did not take, by who tried: the request or the outbox relay",
+	}, []string{"source"})
+	outboxPendingMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "receipts_outbox_pending",
+		Help: "Receipts left in the outbox after the last relay run",
+	})
+	queueDepthGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "receipts_queue_depth",
+		Help: "Receipts waiting for the consumer at the last backlog check",
+	})
+	backpressureGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "receipts_backpressure_active",
+		Help: "1 while new receipts are being held back because the consumer is too far behind",
+	})
+)
+
+// instrument records every request under its route pattern, so /receipts/:Id/points is one series rather than one per Id
+func instrument() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		start := time.Now()
+		context.Next()
+		route := context.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(context.Writer.Status())
+		requestsMetric.WithLabelValues(context.Request.Method, route, status).Inc()
+		requestDurationMetric.WithLabelValues(context.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
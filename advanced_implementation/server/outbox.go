@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"restGo/alerting"
+	"restGo/logging"
+	"restGo/messaging"
+)
+
+// The outbox is a small BadgerDB owned only by the server. A receipt is written here before the client is told it was accepted,
+// and it is removed once it has been handed to the broker. Anything left behind (broker down, server crash) is republished by the relay
+type Outbox struct {
+	db *badger.DB
+}
+
+func openOutbox(path string) (*Outbox, error) {
+	db, err := badger.Open(badger.DefaultOptions(path).WithLogger(logging.Library("badger"))) // DefaultOptions uses SyncWrites so an Add is on disk when it returns
+	if err != nil {
+		return nil, err
+	}
+	return &Outbox{db: db}, nil
+}
+
+func (o *Outbox) Close() error {
+	return o.db.Close()
+}
+
+// Add durably records a receipt that still has to be published
+func (o *Outbox) Add(Id string, body []byte) error {
+	return o.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(Id), body)
+	})
+}
+
+// Remove deletes a receipt once it has been published
+func (o *Outbox) Remove(Id string) error {
+	return o.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(Id))
+	})
+}
+
+// Count returns how many receipts are waiting to be published, without reading them
+func (o *Outbox) Count() (int, error) {
+	count := 0
+	err := o.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Pending returns a copy of every receipt still waiting to be published
+func (o *Outbox) Pending() (map[string][]byte, error) {
+	pending := map[string][]byte{}
+	err := o.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			body, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			pending[string(item.KeyCopy(nil))] = body
+		}
+		return nil
+	})
+	return pending, err
+}
+
+// relay periodically republishes whatever is left in the outbox until ctx is done. It holds off while the consumer is
+// too far behind, so the outbox absorbs the backlog instead of the queue
+func (o *Outbox) relay(ctx context.Context, publisher messaging.Publisher, interval time.Duration, pressure *backpressure) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if pressure.Overloaded() {
+			continue
+		}
+		if left := o.flush(ctx, publisher); left >= 0 {
+			outboxPendingMetric.Set(float64(left))
+		}
+	}
+}
+
+// flush publishes everything in the outbox once, stopping at the first receipt that can't be published. It returns how many
+// receipts are still left
+func (o *Outbox) flush(ctx context.Context, publisher messaging.Publisher) int {
+	pending, err := o.Pending()
+	if err != nil {
+		alerting.Raise(ctx, alerting.StoreFailed, "Failed to read the outbox", logging.Err(err))
+		return -1
+	}
+	left := len(pending)
+	for Id, body := range pending {
+		if err := publisher.Publish(ctx, receiptMessage(ctx, Id, body)); err != nil {
+			publishFailuresMetric.WithLabelValues("relay").Inc()
+			alerting.Raise(ctx, alerting.PublishFailed, "Outbox relay could not publish receipt, will retry", logging.Receipt(Id), logging.Err(err))
+			break // The broker is most likely down, so wait for the next tick
+		}
+		if err := o.Remove(Id); err != nil {
+			slog.WarnContext(ctx, "Outbox relay failed to remove published receipt", logging.Receipt(Id), logging.Err(err))
+		}
+		left--
+	}
+	return left
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"restGo/messaging"
+)
+
+func openTestOutbox(t *testing.T, dir string) *Outbox {
+	t.Helper()
+	outbox, err := openOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { outbox.Close() })
+	return outbox
+}
+
+func addToOutbox(t *testing.T, outbox *Outbox, Ids ...string) {
+	t.Helper()
+	for _, Id := range Ids {
+		if err := outbox.Add(Id, []byte(`{"Id":"`+Id+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func count(t *testing.T, outbox *Outbox) int {
+	t.Helper()
+	left, err := outbox.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return left
+}
+
+func TestOutboxKeepsReceiptsUntilRemoved(t *testing.T) {
+	dir := t.TempDir()
+	outbox, err := openOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addToOutbox(t, outbox, "a", "b")
+	if err := outbox.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Remove("missing"); err != nil {
+		t.Errorf("Remove() = %v for a receipt that isn't there", err)
+	}
+	outbox.Close()
+
+	// What was added survives a restart
+	outbox = openTestOutbox(t, dir)
+	pending, err := outbox.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || string(pending["b"]) != `{"Id":"b"}` {
+		t.Errorf("Pending() = %v after reopening, want only b", pending)
+	}
+	if left := count(t, outbox); left != 1 {
+		t.Errorf("Count() = %d, want 1", left)
+	}
+}
+
+// The final flush at shutdown publishes what the broker didn't take and leaves the rest for the next start
+func TestOutboxFlush(t *testing.T) {
+	outbox := openTestOutbox(t, t.TempDir())
+	addToOutbox(t, outbox, "a", "b", "c")
+	publisher := &stubPublisher{down: true}
+
+	if left := outbox.flush(context.Background(), publisher); left != 3 {
+		t.Errorf("flush() = %d with the broker down, want 3 left", left)
+	}
+	if left := count(t, outbox); left != 3 {
+		t.Errorf("%d receipts in the outbox after a failed flush, want 3", left)
+	}
+
+	publisher.setDown(false)
+	if left := outbox.flush(context.Background(), publisher); left != 0 {
+		t.Errorf("flush() = %d, want 0 left", left)
+	}
+	published := publisher.Published()
+	slices.Sort(published)
+	if !slices.Equal(published, []string{"a", "b", "c"}) {
+		t.Errorf("published %v, want a, b and c", published)
+	}
+	if left := count(t, outbox); left != 0 {
+		t.Errorf("%d receipts in the outbox after flushing, want 0", left)
+	}
+}
+
+func TestOutboxRelay(t *testing.T) {
+	outbox := openTestOutbox(t, t.TempDir())
+	addToOutbox(t, outbox, "a", "b")
+	publisher := &stubPublisher{down: true}
+	reporter := &scriptedBacklog{}
+	pressure := &backpressure{reporter: reporter, threshold: 100, interval: time.Second, mode: outboxOnPressure}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		outbox.relay(ctx, publisher, 10*time.Millisecond, pressure)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	// Nothing is lost while the broker is down, and everything goes out once it is back
+	time.Sleep(50 * time.Millisecond)
+	if left := count(t, outbox); left != 2 {
+		t.Fatalf("%d receipts in the outbox while the broker is down, want 2", left)
+	}
+	publisher.setDown(false)
+	eventually(t, "the relay publishes the receipts", func() bool {
+		return len(publisher.Published()) == 2 && count(t, outbox) == 0
+	})
+
+	// While the consumer is behind, the outbox holds on to new receipts
+	reporter.backlog = messaging.Backlog{Depth: 500}
+	pressure.poll()
+	addToOutbox(t, outbox, "c")
+	time.Sleep(50 * time.Millisecond)
+	if published := publisher.Published(); len(published) != 2 {
+		t.Fatalf("published %v while the consumer is behind", published)
+	}
+	reporter.backlog = messaging.Backlog{Depth: 0}
+	pressure.poll()
+	eventually(t, "the relay publishes the held receipt", func() bool {
+		return len(publisher.Published()) == 3 && count(t, outbox) == 0
+	})
+}
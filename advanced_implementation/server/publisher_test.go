@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"restGo/messaging"
+)
+
+var errBrokerDown = errors.New("broker is down")
+
+// stubPublisher stands in for a broker. It fails while down is set, and while hold is open it keeps every publish waiting
+// until the test closes it
+type stubPublisher struct {
+	mu        sync.Mutex
+	down      bool
+	hold      chan struct{}
+	started   chan string // Receives the Id of every publish as it starts, if set
+	published []string
+}
+
+func (p *stubPublisher) Publish(ctx context.Context, msg messaging.Message) error {
+	p.mu.Lock()
+	hold, started := p.hold, p.started
+	p.mu.Unlock()
+	if started != nil {
+		started <- msg.Id
+	}
+	if hold != nil {
+		select {
+		case <-hold:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.down {
+		return errBrokerDown
+	}
+	p.published = append(p.published, msg.Id)
+	return nil
+}
+
+func (p *stubPublisher) Close() error {
+	return nil
+}
+
+func (p *stubPublisher) setDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = down
+}
+
+func (p *stubPublisher) Published() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.published...)
+}
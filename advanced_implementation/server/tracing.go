@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"restGo/tracing"
+)
+
+// traced starts a span for every request, carrying on the client's trace if it sent a traceparent header. The receipt's publish
+// and, through the message headers, the consumer's processing become children of it
+func traced() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		ctx := tracing.ExtractHTTP(context.Request.Context(), context.Request.Header)
+		route := context.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracing.Tracer().Start(ctx, context.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", context.Request.Method),
+				attribute.String("http.route", route),
+			),
+		)
+		defer span.End()
+		context.Request = context.Request.WithContext(ctx)
+		context.Next()
+		status := context.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprint(status))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"restGo/messaging"
+)
+
+// recordSpans makes every span end up in the returned exporter as soon as it ends, and propagates trace context the way
+// tracing.Setup does
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return exporter
+}
+
+// endedSpans waits until every one of the named spans has ended and returns them by name
+func endedSpans(t *testing.T, exporter *tracetest.InMemoryExporter, names ...string) map[string]tracetest.SpanStub {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		spans := map[string]tracetest.SpanStub{}
+		for _, span := range exporter.GetSpans() {
+			spans[span.Name] = span
+		}
+		missing := ""
+		for _, name := range names {
+			if _, ok := spans[name]; !ok {
+				missing = name
+				break
+			}
+		}
+		if missing == "" {
+			return spans
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("span %q never ended, got %v", missing, exporter.GetSpans())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReceiptIsTracedFromPostToBadger(t *testing.T) {
+	exporter := recordSpans(t)
+	p := newTestPipeline(t)
+	p.stored(t, p.post(t, testReceipt(), nil))
+
+	var (
+		request = "POST /receipts/process"
+		publish = "publish " + messaging.ReceiptsQueue
+		process = "process " + messaging.ReceiptsQueue
+		write   = "badger.WriteBatch"
+	)
+	spans := endedSpans(t, exporter, request, publish, process, write)
+
+	traceId := spans[request].SpanContext.TraceID()
+	for _, name := range []string{publish, process, write} {
+		if got := spans[name].SpanContext.TraceID(); got != traceId {
+			t.Errorf("%s is in trace %s, want %s with the request", name, got, traceId)
+		}
+	}
+	// Each span is the child of the one before it. The consumer only gets the publish span from the message headers
+	chain := []string{request, publish, process, write}
+	for i := 1; i < len(chain); i++ {
+		parent, child := spans[chain[i-1]], spans[chain[i]]
+		if child.Parent.SpanID() != parent.SpanContext.SpanID() {
+			t.Errorf("%s has parent %s, want %s (%s)", chain[i], child.Parent.SpanID(), parent.SpanContext.SpanID(), chain[i-1])
+		}
+	}
+	if !spans[process].Parent.IsRemote() {
+		t.Errorf("%s did not get its parent from the message headers", process)
+	}
+}
+
+func TestReceiptCarriesOnTheClientsTrace(t *testing.T) {
+	exporter := recordSpans(t)
+	p := newTestPipeline(t)
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	p.stored(t, p.post(t, testReceipt(), map[string]string{"traceparent": traceparent}))
+
+	spans := endedSpans(t, exporter, "POST /receipts/process", "badger.WriteBatch")
+	for _, name := range []string{"POST /receipts/process", "badger.WriteBatch"} {
+		if got := spans[name].SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("%s is in trace %s, want the client's", name, got)
+		}
+	}
+}
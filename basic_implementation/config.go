@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Config is the basic server's share of the settings the advanced implementation reads, loaded the same way: defaults, then
+// a JSON file (--config or CONFIG_FILE), then the environment, then flags
+type Config struct {
+	Listen string `json:"listen"` // LISTEN_ADDR or --listen
+}
+
+func loadConfig(args []string) (Config, bool, error) {
+	config := Config{Listen: "0.0.0.0:9090"} // Changed from localhost to 0.0.0.0 for docker
+
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "JSON file with settings, overridden by the environment and flags")
+	printConfig := flags.Bool("print-config", false, "print the settings in effect and exit")
+	listen := flags.String("listen", "", "overrides LISTEN_ADDR (default "+config.Listen+")")
+	if err := flags.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return Config{}, false, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields() // A misspelt setting would otherwise be silently ignored
+		if err := decoder.Decode(&config); err != nil {
+			return Config{}, false, fmt.Errorf("reading %s: %w", *file, err)
+		}
+	}
+	if value := os.Getenv("LISTEN_ADDR"); value != "" {
+		config.Listen = value
+	}
+	if *listen != "" {
+		config.Listen = *listen
+	}
+	if config.Listen == "" {
+		return Config{}, false, errors.New("listen must not be empty")
+	}
+	return config, *printConfig, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// A copy of the advanced implementation's scoring/datetime.go, which is tested there. Both implementations are separate modules
+// named restGo and each image is built from its own directory, so this one can't import the other's scoring package.
+//
+// Validation and scoring both read purchaseDate and purchaseTime through these, so a receipt is never accepted in a format the
+// rules then read differently.
+//
+// Accepted purchaseDate formats:
+//   - YYYY-MM-DD, e.g. 2022-01-02
+//   - The same without leading zeros, e.g. 2022-1-2
+//
+// Accepted purchaseTime formats, with or without leading zeros on any part:
+//   - 24-hour H:MM or H:MM:SS, hours 0-23, e.g. 13:01, 9:5, 14:30:59
+//   - 12-hour H:MM AM/PM or H:MM:SS AM/PM, hours 1-12, e.g. 2:30 PM, 12:00am, 11:59:59 pm. The space is optional and AM/PM
+//     can be in any case. 12 AM is midnight and 12 PM is noon
+
+var (
+	errInvalidDate = errors.New("purchase date must look like YYYY-MM-DD")
+	errInvalidTime = errors.New("purchase time must look like HH:MM, HH:MM:SS or either of them followed by AM/PM")
+)
+
+// timeOfDay is a purchaseTime on the 24-hour clock
+type timeOfDay struct {
+	Hour, Minute, Second int
+}
+
+// parsePurchaseDate reads a purchaseDate, rejecting days that don't exist such as 2022-02-30
+func parsePurchaseDate(dateStr string) (time.Time, error) {
+	// "1" and "2" take the month and day with or without a leading zero
+	date, err := time.Parse("2006-1-2", dateStr)
+	if err != nil {
+		return time.Time{}, errInvalidDate
+	}
+	return date, nil
+}
+
+// parsePurchaseTime reads a purchaseTime in any of the formats above
+func parsePurchaseTime(timeStr string) (timeOfDay, error) {
+	clock, meridiem := timeStr, ""
+	if upper := strings.ToUpper(timeStr); strings.HasSuffix(upper, "AM") || strings.HasSuffix(upper, "PM") {
+		meridiem = upper[len(upper)-2:]
+		clock = strings.TrimSuffix(timeStr[:len(timeStr)-2], " ")
+	}
+
+	parts := strings.Split(clock, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return timeOfDay{}, errInvalidTime
+	}
+	var fields [3]int
+	for i, part := range parts {
+		n, ok := smallNumber(part)
+		if !ok {
+			return timeOfDay{}, errInvalidTime
+		}
+		fields[i] = n
+	}
+	t := timeOfDay{Hour: fields[0], Minute: fields[1], Second: fields[2]}
+	if t.Minute > 59 || t.Second > 59 {
+		return timeOfDay{}, errInvalidTime
+	}
+
+	switch meridiem {
+	case "":
+		if t.Hour > 23 {
+			return timeOfDay{}, errInvalidTime
+		}
+	default:
+		if t.Hour < 1 || t.Hour > 12 {
+			return timeOfDay{}, errInvalidTime
+		}
+		t.Hour %= 12
+		if meridiem == "PM" {
+			t.Hour += 12
+		}
+	}
+	return t, nil
+}
+
+// smallNumber reads one or two digits. strconv.Atoi would also let through signs and longer numbers like "007"
+func smallNumber(s string) (int, bool) {
+	if len(s) < 1 || len(s) > 2 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+// The parsing itself is tested with the advanced implementation's copy. These check that validation and scoring here agree on it
+func TestPurchaseDatePoints(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int // -1 if the date is invalid
+	}{
+		{"2022-01-01", 6},
+		{"2022-1-1", 6},
+		{"2022-01-02", 0},
+		{"2024-02-29", 6},
+		{"2022-02-30", -1},
+		{"2022/01/01", -1},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			points, err := calculatePurchaseDatePoints(test.input)
+			if points != test.want {
+				t.Errorf("calculatePurchaseDatePoints(%q) = %d, %v, want %d", test.input, points, err, test.want)
+			}
+			if isValidDate(test.input) != (test.want >= 0) {
+				t.Errorf("isValidDate(%q) = %v, but it scores %d", test.input, isValidDate(test.input), points)
+			}
+		})
+	}
+}
+
+func TestPurchaseTimePoints(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int // -1 if the time is invalid
+	}{
+		{"13:59", 0},
+		{"14:00", 10},
+		{"15:59:59", 10},
+		{"16:00", 0},
+		// The 12-hour clock scores the same hours
+		{"2:30 PM", 10},
+		{"2:30 AM", 0},
+		{"12:00pm", 0},
+		{"24:00", -1},
+		{"13:00 PM", -1},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			points, err := calculatePurchaseTimePoints(test.input)
+			if points != test.want {
+				t.Errorf("calculatePurchaseTimePoints(%q) = %d, %v, want %d", test.input, points, err, test.want)
+			}
+			if isValidTime(test.input) != (test.want >= 0) {
+				t.Errorf("isValidTime(%q) = %v, but it scores %d", test.input, isValidTime(test.input), points)
+			}
+		})
+	}
+}
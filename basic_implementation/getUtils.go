@@ -62,24 +62,23 @@ func calculatePurchaseDayPoints(purchaseDay int) int {
 }
 
 func calculatePurchaseDatePoints(purchaseDate string) (int, error) {
-	parseDate := strings.Split(purchaseDate, "-")
-	purchaseDay, err := strconv.Atoi(parseDate[2])
+	date, err := parsePurchaseDate(purchaseDate)
 	if err != nil {
-		return -1, errors.New("error in converting purchase day to integer")
+		return -1, err
 	}
 	total := 0
-	total += calculatePurchaseDayPoints(purchaseDay)
+	total += calculatePurchaseDayPoints(date.Day())
 
 	return total, nil
 }
 
 func calculatePurchaseTimePoints(purchaseTime string) (int, error) {
-	parseTime := strings.Split(purchaseTime, ":")
-	hour, err := strconv.Atoi(parseTime[0])
+	clock, err := parsePurchaseTime(purchaseTime)
 	if err != nil {
-		return -1, errors.New("error in converting purchase hour to integer")
+		return -1, err
 	}
-	if hour >= 14 && hour < 16 {
+	// The hour is on the 24-hour clock whichever format the receipt used
+	if clock.Hour >= 14 && clock.Hour < 16 {
 		return 10, nil
 	}
 	return 0, nil
@@ -3,8 +3,11 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
 )
@@ -72,14 +75,30 @@ func addReceipt(context *gin.Context) {
 	}
 	receipts[Id] = newReceipt
 	context.IndentedJSON(http.StatusOK, gin.H{"Id": Id})
-	fmt.Println(receipts)
+	slog.Info("Accepted receipt", "receipt_id", Id, "stored", len(receipts)) // Never the receipts themselves
 }
 
 func main() {
+	config, printConfig, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Println("Invalid configuration:", err)
+		os.Exit(2)
+	}
+	if printConfig {
+		out, _ := json.MarshalIndent(config, "", "  ") // Nothing secret in here yet
+		fmt.Println(string(out))
+		return
+	}
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
 	router := gin.Default()
 	// Routes
 	router.GET("/receipts/:Id/points", getPoints)
 	router.POST("/receipts/process", addReceipt)
 	// Run the server
-	router.Run("0.0.0.0:9090") // Changed from localhost to 0.0.0.0 for docker
+	router.Run(config.Listen)
 }
@@ -3,7 +3,7 @@ package main
 import (
 	"math/rand"
 	"reflect"
-	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,34 +19,35 @@ func generateId(length int) string {
 }
 
 func isValidDate(dateStr string) bool {
-	// dates can have leading zeros
-	layouts := []string{"2006-01-02", "2006-1-2"}
-	for _, layout := range layouts {
-		_, err := time.Parse(layout, dateStr)
-		if err == nil {
-			return true
-		}
-	}
-	return false
+	_, err := parsePurchaseDate(dateStr)
+	return err == nil
 }
 
 func isValidTime(timeStr string) bool {
-	// need to allow "HH:mm" and "H:m"
-	layouts := []string{"15:04", "3:4"}
+	_, err := parsePurchaseTime(timeStr)
+	return err == nil
+}
 
-	for _, layout := range layouts {
-		if _, err := time.Parse(layout, timeStr); err == nil {
-			return true
-		}
+// isValidNumber accepts plain amounts such as 12, 12.5 or 12.50. ParseFloat alone also lets through NaN, Inf, 1e9 and negatives
+func isValidNumber(numStr string) bool {
+	whole, cents, hasCents := strings.Cut(numStr, ".")
+	if !allDigits(whole) {
+		return false
 	}
-	return false
+	return !hasCents || (len(cents) <= 2 && allDigits(cents))
 }
 
-func isValidNumber(numStr string) bool {
-	_, err := strconv.ParseFloat(numStr, 64)
-	return err == nil
+func allDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
 }
 
+// validateReceiptFields only checks the shape of a receipt. The advanced implementation's policy on old purchases, totals and
+// item counts is not applied here
 func validateReceiptFields(receipt Receipt) bool {
 	// Check for empty fields
 	if receipt.Retailer == "" || receipt.PurchaseDate == "" || receipt.PurchaseTime == "" ||